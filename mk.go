@@ -1176,17 +1176,47 @@ var packages = []*Package{
         output: "_obj/utilz/timer",
         files:  []string{"src/utilz/timer.go"},
     },
+    &Package{
+        name:   "event",
+        full:    "utilz/event",
+        output: "_obj/utilz/event",
+        files:  []string{"src/utilz/event.go"},
+    },
+    &Package{
+        name:   "semaphore",
+        full:    "utilz/semaphore",
+        output: "_obj/utilz/semaphore",
+        files:  []string{"src/utilz/semaphore.go"},
+    },
     &Package{
         name:   "gopt",
         full:    "parse/gopt",
         output: "_obj/parse/gopt",
         files:  []string{"src/parse/gopt.go","src/parse/option.go"},
     },
+    &Package{
+        name:   "gomod",
+        full:    "parse/gomod",
+        output: "_obj/parse/gomod",
+        files:  []string{"src/parse/gomod.go"},
+    },
+    &Package{
+        name:   "tags",
+        full:    "parse/tags",
+        output: "_obj/parse/tags",
+        files:  []string{"src/parse/tags.go"},
+    },
+    &Package{
+        name:   "query",
+        full:    "parse/query",
+        output: "_obj/parse/query",
+        files:  []string{"src/parse/query.go"},
+    },
     &Package{
         name:   "dag",
         full:    "cmplr/dag",
         output: "_obj/cmplr/dag",
-        files:  []string{"src/cmplr/dag.go"},
+        files:  []string{"src/cmplr/affected.go","src/cmplr/cycle.go","src/cmplr/dag.go","src/cmplr/imports.go","src/cmplr/parsecache.go","src/cmplr/query.go","src/cmplr/state.go","src/cmplr/testmain.go"},
     },
     &Package{
         name:   "gdmake",
@@ -1198,13 +1228,13 @@ var packages = []*Package{
         name:   "compiler",
         full:    "cmplr/compiler",
         output: "_obj/cmplr/compiler",
-        files:  []string{"src/cmplr/compiler.go"},
+        files:  []string{"src/cmplr/cgo.go","src/cmplr/compiler.go","src/cmplr/cover.go","src/cmplr/gotool.go","src/cmplr/report.go","src/cmplr/testrun.go"},
     },
     &Package{
         name:   "main",
         full:    "start/main",
         output: "_obj/start/main",
-        files:  []string{"src/start/main.go"},
+        files:  []string{"src/start/main.go","src/start/serve.go","src/start/watch.go"},
     },
 
 }
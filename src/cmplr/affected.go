@@ -0,0 +1,92 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "path/filepath"
+    "strings"
+    "utilz/stringset"
+)
+
+// --since/--changed: only test packages touched by a change, and the
+// packages depending on them (children, recursively). A file touches
+// the package living in its directory, or in a parent directory if
+// the file is part of that package's testdata. Objects written next
+// to the source (foo.a, foo.importcfg) are not changes.
+
+var testOnly *stringset.StringSet // nil => test everything
+
+// go files + what cgo compiles
+var sourceExt = map[string]bool{
+    ".go": true, ".c": true, ".h": true, ".s": true, ".S": true,
+    ".cc": true, ".cpp": true, ".cxx": true, ".hh": true, ".hpp": true,
+    ".m": true, ".syso": true,
+}
+
+// call after GraphBuilder, children are needed
+func (d Dag) TestOnly(changed []string) {
+
+    testOnly = stringset.New()
+
+    for _, file := range changed {
+        abs, e := filepath.Abs(file)
+        if e != nil {
+            continue
+        }
+        for _, v := range d {
+            if v.touchedBy(abs) {
+                v.addDependents(testOnly)
+            }
+        }
+    }
+}
+
+// no tests left to run after TestOnly
+func (d Dag) NothingToTest() bool {
+    return testOnly != nil && testOnly.Len() == 0
+}
+
+func (p *Package) touchedBy(abs string) bool {
+
+    srcdir, e := filepath.Abs(p.SrcDir())
+
+    if e != nil {
+        return false
+    }
+
+    if filepath.Dir(abs) == srcdir {
+        return sourceExt[filepath.Ext(abs)]
+    }
+
+    testdata := filepath.Join(srcdir, "testdata") + string(filepath.Separator)
+
+    return strings.HasPrefix(abs, testdata)
+}
+
+func (p *Package) addDependents(set *stringset.StringSet) {
+
+    if !set.Add(p.Name) {
+        return // seen
+    }
+
+    for i := 0; i < len(p.children); i++ {
+        p.children[i].addDependents(set)
+    }
+}
+
+func skipTests(p *Package) bool {
+    return testOnly != nil && !testOnly.Contains(p.Name)
+}
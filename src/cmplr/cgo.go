@@ -0,0 +1,346 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+    "cmplr/dag"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "log"
+    "os"
+    "os/exec"
+    "parse/tags"
+    "path/filepath"
+    "strings"
+    "sync"
+    "utilz/handy"
+)
+
+// cgo: packages with import "C" ('go' backend only). What the go
+// tool does, spelled out as extra commands for the package:
+//
+//  go tool cgo -objdir O -importpath P -ldflags LDFLAGS -- CFLAGS x.go
+//  cc -c O/x.cgo2.c O/_cgo_export.c *.c  (one .o each)
+//  cc -o O/_cgo_.o O/_cgo_main.o *.o LDFLAGS
+//  go tool cgo -dynpackage p -dynimport O/_cgo_.o -dynout O/_cgo_import.go
+//  go tool compile ... O/_cgo_gotypes.go O/x.cgo1.go O/_cgo_import.go
+//  go tool pack r P.a *.o
+//
+// O is a hidden directory next to the object file: .name.cgo
+
+// generated code imports these
+var cgoImports = []string{"runtime/cgo", "syscall", "unsafe"}
+
+var cgoUsed bool // some package uses cgo => linker needs -extld
+var cgoLock = new(sync.Mutex)
+
+// #cgo directives of a package
+type cgoFlags struct {
+    cppflags, cflags, ldflags []string
+}
+
+func usesCgo(pkg *dag.Package) bool {
+    return len(pkg.CgoFiles()) > 0
+}
+
+// other backends get a clear message instead of compiler errors
+func refuseCgo(pkgs []*dag.Package) {
+    for i := 0; i < len(pkgs); i++ {
+        if usesCgo(pkgs[i]) {
+            log.Fatalf("[ERROR] %s: cgo (import \"C\") requires the 'go' backend\n",
+                pkgs[i].Name)
+        }
+    }
+}
+
+func cgoObjdir(pkg *dag.Package) string {
+    dir, base := filepath.Split(strings.TrimSuffix(pkg.Output, suffix))
+    return filepath.Join(dir, "."+base+".cgo")
+}
+
+// fill in Pre/Post + Files for the compile step of a cgo package
+func cgoArgv(pkg *dag.Package) (files []string) {
+
+    cgoLock.Lock()
+    cgoUsed = true
+    cgoLock.Unlock()
+
+    objdir := cgoObjdir(pkg)
+    handy.DirOrMkdir(objdir)
+
+    srcdir := filepath.Dir(pkg.Files[0])
+    absdir, _ := filepath.Abs(srcdir)
+
+    cgoFiles := pkg.CgoFiles()
+    flags := readCgoFlags(pkg.Name, cgoFiles, absdir)
+
+    cc := cCompiler()
+    cflags := append([]string{"-I", objdir, "-I", srcdir}, flags.cppflags...)
+    cflags = append(cflags, flags.cflags...)
+
+    // go tool cgo
+    argv := []string{pathCompiler, "tool", "cgo", "-objdir", objdir,
+        "-importpath", pkg.Name}
+    if len(flags.ldflags) > 0 {
+        argv = append(argv, "-ldflags", strings.Join(flags.ldflags, " "))
+    }
+    argv = append(argv, "--")
+    argv = append(argv, cflags...)
+    argv = append(argv, cgoFiles...)
+
+    pre := [][]string{argv}
+
+    // C sources: generated + the ones living in the package
+    csrc := make([]string, 0)
+    for i := 0; i < len(cgoFiles); i++ {
+        base := strings.TrimSuffix(filepath.Base(cgoFiles[i]), ".go")
+        csrc = append(csrc, filepath.Join(objdir, base+".cgo2.c"))
+    }
+    csrc = append(csrc, filepath.Join(objdir, "_cgo_export.c"))
+
+    native := cFiles(srcdir)
+    csrc = append(csrc, native...)
+
+    ccflags := append(append([]string{}, cflags...), archFlags()...)
+    ccflags = append(ccflags, defaultCFlags()...)
+
+    objects := make([]string, 0)
+    for i := 0; i < len(csrc); i++ {
+        base := strings.TrimSuffix(filepath.Base(csrc[i]), ".c")
+        object := filepath.Join(objdir, base+".o")
+        cmd := append([]string{cc}, ccflags...)
+        cmd = append(cmd, "-c", csrc[i], "-o", object)
+        pre = append(pre, cmd)
+        objects = append(objects, object)
+    }
+
+    // link a dummy binary to find dynamic imports
+    cgoMain := filepath.Join(objdir, "_cgo_main.o")
+    cgoBin := filepath.Join(objdir, "_cgo_.o")
+    cgoImport := filepath.Join(objdir, "_cgo_import.go")
+
+    cmd := append([]string{cc}, ccflags...)
+    cmd = append(cmd, "-c", filepath.Join(objdir, "_cgo_main.c"), "-o", cgoMain)
+    pre = append(pre, cmd)
+
+    cmd = append([]string{cc}, archFlags()...)
+    cmd = append(cmd, "-o", cgoBin, cgoMain)
+    cmd = append(cmd, objects...)
+    cmd = append(cmd, flags.ldflags...)
+    pre = append(pre, cmd)
+
+    pre = append(pre, []string{pathCompiler, "tool", "cgo", "-dynpackage",
+        pkg.ShortName, "-dynimport", cgoBin, "-dynout", cgoImport})
+
+    pkg.Pre = pre
+
+    // C objects go into the package archive
+    pack := []string{pathCompiler, "tool", "pack", "r", pkg.Output}
+    pkg.Post = [][]string{append(pack, objects...)}
+
+    // Go files for the compiler: generated + the ones without cgo
+    files = append(files, filepath.Join(objdir, "_cgo_gotypes.go"))
+    for i := 0; i < len(cgoFiles); i++ {
+        base := strings.TrimSuffix(filepath.Base(cgoFiles[i]), ".go")
+        files = append(files, filepath.Join(objdir, base+".cgo1.go"))
+    }
+    files = append(files, cgoImport)
+
+    isCgo := make(map[string]bool)
+    for i := 0; i < len(cgoFiles); i++ {
+        isCgo[cgoFiles[i]] = true
+    }
+    for i := 0; i < len(pkg.Files); i++ {
+        if !isCgo[pkg.Files[i]] {
+            files = append(files, pkg.Files[i])
+        }
+    }
+
+    // C sources + headers change the object as well
+    pkg.Inputs = append(pkg.Inputs, native...)
+    pkg.Inputs = append(pkg.Inputs, cHeaders(srcdir)...)
+
+    return files
+}
+
+// #cgo [constraints] CFLAGS|CPPFLAGS|LDFLAGS|pkg-config: values
+func readCgoFlags(name string, files []string, absdir string) *cgoFlags {
+
+    flags := new(cgoFlags)
+
+    for i := 0; i < len(files); i++ {
+
+        preamble := cgoPreamble(files[i])
+        lines := strings.Split(preamble, "\n")
+
+        for j := 0; j < len(lines); j++ {
+
+            line := strings.TrimSpace(lines[j])
+
+            if !strings.HasPrefix(line, "#cgo ") {
+                continue
+            }
+
+            colon := strings.Index(line, ":")
+
+            if colon < 0 {
+                log.Fatalf("[ERROR] %s: bad #cgo line: %s\n", files[i], line)
+            }
+
+            fields := strings.Fields(line[len("#cgo "):colon])
+
+            if len(fields) == 0 {
+                log.Fatalf("[ERROR] %s: bad #cgo line: %s\n", files[i], line)
+            }
+
+            verb := fields[len(fields)-1]
+
+            if !tags.MatchExpr(strings.Join(fields[:len(fields)-1], " ")) {
+                continue
+            }
+
+            value := strings.ReplaceAll(line[colon+1:], "${SRCDIR}", absdir)
+            args := strings.Fields(value)
+
+            switch verb {
+            case "CFLAGS":
+                flags.cflags = append(flags.cflags, args...)
+            case "CPPFLAGS":
+                flags.cppflags = append(flags.cppflags, args...)
+            case "LDFLAGS":
+                flags.ldflags = append(flags.ldflags, args...)
+            case "pkg-config":
+                flags.cflags = append(flags.cflags, pkgConfig(name, "--cflags", args)...)
+                flags.ldflags = append(flags.ldflags, pkgConfig(name, "--libs", args)...)
+            case "CXXFLAGS", "FFLAGS":
+                log.Printf("[WARNING] %s: #cgo %s ignored (C only)\n", files[i], verb)
+            default:
+                log.Fatalf("[ERROR] %s: unknown #cgo directive: %s\n", files[i], verb)
+            }
+        }
+    }
+
+    return flags
+}
+
+// comment above import "C"
+func cgoPreamble(pathname string) string {
+
+    fset := token.NewFileSet()
+    mode := parser.ImportsOnly | parser.ParseComments
+    tree, e := parser.ParseFile(fset, pathname, nil, mode)
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    for _, decl := range tree.Decls {
+        gen, ok := decl.(*ast.GenDecl)
+        if !ok {
+            continue
+        }
+        for _, spec := range gen.Specs {
+            imprt, ok := spec.(*ast.ImportSpec)
+            if !ok || imprt.Path.Value != `"C"` {
+                continue
+            }
+            if imprt.Doc != nil {
+                return imprt.Doc.Text()
+            }
+            if gen.Doc != nil {
+                return gen.Doc.Text()
+            }
+        }
+    }
+
+    return ""
+}
+
+func pkgConfig(name, what string, libs []string) []string {
+
+    argv := append([]string{"pkg-config", what}, libs...)
+    stdout, stderr, _, e := handy.Capture(argv)
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s: pkg-config: %s\n%s", name, e, stderr)
+    }
+
+    return strings.Fields(stdout)
+}
+
+// $CC, cross compiling: GNU triplet + gcc
+func cCompiler() string {
+
+    if cc := os.Getenv("CC"); cc != "" {
+        return cc
+    }
+
+    if handy.CrossCompiling() {
+        return gnuTriplet() + "-gcc"
+    }
+
+    if _, e := exec.LookPath("gcc"); e == nil {
+        return "gcc"
+    }
+
+    return "cc"
+}
+
+func archFlags() []string {
+    switch handy.GOARCH() {
+    case "amd64":
+        return []string{"-fPIC", "-m64", "-pthread"}
+    case "386":
+        return []string{"-m32", "-pthread"}
+    case "arm":
+        return []string{"-fPIC", "-marm", "-pthread"}
+    }
+    return []string{"-fPIC", "-pthread"}
+}
+
+// $CGO_CFLAGS, or what the go tool uses by default
+func defaultCFlags() []string {
+    if cflags := os.Getenv("CGO_CFLAGS"); cflags != "" {
+        return strings.Fields(cflags)
+    }
+    return []string{"-g", "-O2"}
+}
+
+// *.c files of the package, respecting build constraints
+func cFiles(dir string) []string {
+    return filesWithSuffix(dir, ".c", true)
+}
+
+func cHeaders(dir string) []string {
+    return filesWithSuffix(dir, ".h", false)
+}
+
+func filesWithSuffix(dir, suffix string, constrained bool) []string {
+
+    files := make([]string, 0)
+    matches, _ := filepath.Glob(filepath.Join(dir, "*"+suffix))
+
+    for i := 0; i < len(matches); i++ {
+        if constrained && !tags.MatchName(strings.TrimSuffix(
+            filepath.Base(matches[i]), suffix)+".go") {
+            continue
+        }
+        files = append(files, matches[i])
+    }
+
+    return files
+}
@@ -23,10 +23,14 @@ import (
     "os/exec"
     "path/filepath"
     "regexp"
+    "runtime"
     "strings"
+    "sync"
+    "utilz/event"
     "utilz/global"
     "utilz/handy"
     "utilz/say"
+    "utilz/semaphore"
     "utilz/stringset"
     "utilz/walker"
 )
@@ -38,18 +42,44 @@ var pathLinker string
 var pathCompiler string
 var suffix string
 
+// build state lives next to the objects, see dag/state.go
+const stateName = ".gdstate"
+
 func Init(srcdir string, include []string) {
 
     srcroot = srcdir
     includes = include
+    libroot = libDir(srcdir)
+
+    InitBackend()
+
+    dag.LoadState(filepath.Join(libroot, stateName))
+}
+
+// objects go to -lib (or src), with an explicit target (--goos,
+// --goarch) each target gets a subdirectory, i.e. no collisions
+func libDir(srcdir string) (dir string) {
+
+    dir = srcdir
 
     if global.GetString("-lib") != "" {
-        libroot = global.GetString("-lib")
-    } else {
-        libroot = srcroot
+        dir = global.GetString("-lib")
     }
 
-    InitBackend()
+    if global.GetString("-goos") != "" || global.GetString("-goarch") != "" {
+        dir = filepath.Join(dir, handy.GOOS()+"_"+handy.GOARCH())
+    }
+
+    return dir
+}
+
+// objects are not placed next to the source (-lib or target dir)
+func SeparateLib() bool {
+    return libroot != srcroot
+}
+
+func LibRoot() string {
+    return libroot
 }
 
 func InitBackend(){
@@ -61,6 +91,8 @@ func InitBackend(){
         gc()
     case "express":
         express()
+    case "go":
+        goTool()
     default:
         log.Fatalf("[ERROR] '%s' unknown backend\n",
             global.GetString("-backend"))
@@ -95,14 +127,14 @@ func gc() {
         C   string // C:compiler
         L   string // L:linker
         R   string // R:goroot
-        O   string // O:goOS
+        H   string // H:host, target is passed on in $GOOS/$GOARCH
     )
 
     var err error
 
     A = handy.GOARCH()
     R = handy.GOROOT()
-    O = handy.GOOS()
+    H = runtime.GOOS + "_" + runtime.GOARCH
 
     switch A {
     case "arm":
@@ -121,7 +153,7 @@ func gc() {
         log.Fatalf("[ERROR] unknown architecture: %s\n", A)
     }
 
-    path_C := filepath.Join(R, "pkg", "tool", (O + "_" + A), C)
+    path_C := filepath.Join(R, "pkg", "tool", H, C)
 
     pathCompiler, err = exec.LookPath(path_C)
 
@@ -129,7 +161,7 @@ func gc() {
         log.Fatalf("[ERROR] could not find compiler: %s\n", C)
     }
 
-    path_L := filepath.Join(R, "pkg", "tool", (O + "_" + A), L)
+    path_L := filepath.Join(R, "pkg", "tool", H, L)
 
     pathLinker, err = exec.LookPath(path_L)
 
@@ -144,8 +176,13 @@ func gc() {
 func gcc() {
 
     var err error
+    var gccgo string = "gccgo"
+
+    if handy.CrossCompiling() {
+        gccgo = gnuTriplet() + "-gccgo"
+    }
 
-    pathCompiler, err = exec.LookPath("gccgo")
+    pathCompiler, err = exec.LookPath(gccgo)
 
     if err != nil {
         log.Fatalf("[ERROR] could not find compiler: %s\n", err)
@@ -156,12 +193,58 @@ func gcc() {
     suffix = ".o"
 }
 
-func CreateArgv(pkgs []*dag.Package) {
+// cross compilers for gccgo are prefixed with a GNU triplet
+func gnuTriplet() string {
+
+    arch := map[string]string{
+        "386":     "i686",
+        "amd64":   "x86_64",
+        "arm":     "arm",
+        "arm64":   "aarch64",
+        "ppc64":   "powerpc64",
+        "ppc64le": "powerpc64le",
+        "s390x":   "s390x",
+        "riscv64": "riscv64",
+    }
+
+    system := map[string]string{
+        "linux":   "linux-gnu",
+        "windows": "w64-mingw32",
+        "freebsd": "freebsd",
+    }
+
+    a, aok := arch[handy.GOARCH()]
+    o, ook := system[handy.GOOS()]
+
+    if !aok || !ook {
+        log.Fatalf("[ERROR] gccgo: unknown target: %s_%s\n",
+            handy.GOOS(), handy.GOARCH())
+    }
+
+    if handy.GOOS() == "linux" && handy.GOARCH() == "arm" {
+        o = "linux-gnueabihf"
+    }
+
+    if handy.GOOS() == "windows" {
+        return a + "-" + o
+    }
+
+    return a + "-unknown-" + o
+}
+
+// go backend: error if some import cannot be resolved
+func CreateArgv(pkgs []*dag.Package) error {
 
     var argv []string
 
     includeLen := len(includes)
 
+    if global.GetString("-backend") == "go" {
+        return createGoArgv(pkgs)
+    }
+
+    refuseCgo(pkgs)
+
     for y := 0; y < len(pkgs); y++ {
 
         argv = make([]string, 0)
@@ -173,7 +256,7 @@ func CreateArgv(pkgs []*dag.Package) {
             argv = append(argv, includes[y])
         }
 
-        golibs := handy.GoPathImports(global.GetString("-backend"))
+        golibs := goPathImports(global.GetString("-backend"))
         for j := 0; j < len(golibs); j++ {
             argv = append(argv, "-I")
             argv = append(argv, golibs[j])
@@ -184,8 +267,10 @@ func CreateArgv(pkgs []*dag.Package) {
             argv = append(argv, "-c")
         }
 
+        pkgs[y].Output = filepath.Join(libroot, pkgs[y].Stem()) + suffix
+
         argv = append(argv, "-o")
-        argv = append(argv, filepath.Join(libroot, pkgs[y].Name)+suffix)
+        argv = append(argv, pkgs[y].Output)
 
         for z := 0; z < len(pkgs[y].Files); z++ {
             argv = append(argv, pkgs[y].Files[z])
@@ -193,14 +278,16 @@ func CreateArgv(pkgs []*dag.Package) {
 
         pkgs[y].Argv = argv
     }
+
+    return nil
 }
 
-func CreateLibArgv(pkgs []*dag.Package) {
+func CreateLibArgv(pkgs []*dag.Package) error {
 
     ss := stringset.New()
     for i := range pkgs {
-        if len(pkgs[i].Name) > len(pkgs[i].ShortName) {
-            ss.Add(pkgs[i].Name[:(len(pkgs[i].Name) - len(pkgs[i].ShortName))])
+        if strings.Contains(pkgs[i].Stem(), "/") {
+            ss.Add(filepath.Dir(filepath.FromSlash(pkgs[i].Stem())))
         }
     }
     slice := ss.Slice()
@@ -209,15 +296,18 @@ func CreateLibArgv(pkgs []*dag.Package) {
         handy.DirOrMkdir(slice[i])
     }
 
-    CreateArgv(pkgs)
-
+    return CreateArgv(pkgs)
 }
 
 func Dryrun(pkgs []*dag.Package) {
-    binary := filepath.Base(pathCompiler)
     for y := 0; y < len(pkgs); y++ {
-        args := strings.Join(pkgs[y].Argv[1:], " ")
-        fmt.Printf("%s %s || exit 1\n", binary, args)
+        cmds := pkgs[y].Commands()
+        for i := 0; i < len(cmds); i++ {
+            binary := filepath.Base(cmds[i][0])
+            args := strings.Join(cmds[i][1:], " ")
+            event.Dryrun(&event.Event{Package: pkgs[y].Name, Argv: cmds[i]},
+                fmt.Sprintf("%s %s || exit 1\n", binary, args))
+        }
     }
 }
 
@@ -225,6 +315,9 @@ func Dryrun(pkgs []*dag.Package) {
 // after release.r60.1 this is used for all compile jobs
 func Compile(pkgs []*dag.Package) bool {
     // set indegree, i.e. how many jobs to wait for
+    for y := 0; y < len(pkgs); y++ {
+        pkgs[y].Indegree = 0
+    }
     for y := 0; y < len(pkgs); y++ {
         pkgs[y].ResetIndegree()
     }
@@ -232,19 +325,79 @@ func Compile(pkgs []*dag.Package) bool {
     for y := 0; y < len(pkgs); y++ {
         pkgs[y].InitWaitGroup()
     }
-    // start up one go-routine for each package
+    // start up one go-routine for each package, at most
+    // -jobs of them will run the compiler at the same time
     ch := make(chan int)
+    slots := semaphore.New(global.GetInt("-jobs"))
     for y := 0; y < len(pkgs); y++ {
-        go pkgs[y].Compile(ch)
+        go pkgs[y].Compile(ch, slots)
     }
     // make sure all jobs finished, i.e. drain channel
     for y := 0; y < len(pkgs); y++ {
         _ = <-ch
     }
     close(ch)
+    dag.SaveState()
     return !dag.OldPkgYet()
 }
 
+// print what happened to each package during compile (-keep-going),
+// returns false if any package failed or was skipped
+func Summary(pkgs []*dag.Package) bool {
+
+    var built, up2date, failed, skipped []string
+
+    for i := 0; i < len(pkgs); i++ {
+        switch pkgs[i].Status {
+        case dag.Built:
+            built = append(built, pkgs[i].Name)
+        case dag.Up2date:
+            up2date = append(up2date, pkgs[i].Name)
+        case dag.Failed:
+            failed = append(failed, pkgs[i].Name)
+        case dag.Skipped:
+            skipped = append(skipped, pkgs[i].Name)
+        }
+    }
+
+    ok := Compiled(pkgs)
+
+    // errors are printed even when -quiet
+    out := say.Printf
+    if !ok {
+        out = func(f string, args ...interface{}) (int, error) {
+            return fmt.Fprintf(os.Stderr, f, args...)
+        }
+    }
+
+    out("--------------------------------------\n")
+    out(" built: %d  up 2 date: %d  failed: %d  skipped: %d\n",
+        len(built), len(up2date), len(failed), len(skipped))
+    out("--------------------------------------\n")
+
+    for i := 0; i < len(failed); i++ {
+        out(" failed   : %s\n", failed[i])
+    }
+    for i := 0; i < len(skipped); i++ {
+        out(" skipped  : %s\n", skipped[i])
+    }
+    for i := 0; i < len(built); i++ {
+        out(" built    : %s\n", built[i])
+    }
+
+    return ok
+}
+
+// Summary without the printing, false if any package failed or was skipped
+func Compiled(pkgs []*dag.Package) bool {
+    for i := 0; i < len(pkgs); i++ {
+        if pkgs[i].Status == dag.Failed || pkgs[i].Status == dag.Skipped {
+            return false
+        }
+    }
+    return true
+}
+
 // for removal of temoprary packages created for testing and so on..
 func DeletePackages(pkgs []*dag.Package) bool {
 
@@ -256,11 +409,17 @@ func DeletePackages(pkgs []*dag.Package) bool {
             handy.Delete(pkgs[i].Files[y], false)
         }
         if !global.GetBool("-dryrun") {
-            pcompile := filepath.Join(libroot, pkgs[i].Name) + suffix
+            pcompile := filepath.Join(libroot, pkgs[i].Stem()) + suffix
             ok = handy.Delete(pcompile, false)
+            removeImportcfg(filepath.Join(libroot, pkgs[i].Stem()) + ".importcfg")
+            dag.ForgetState(pkgs[i].Name)
         }
     }
 
+    if !global.GetBool("-dryrun") {
+        dag.SaveState()
+    }
+
     return ok
 }
 
@@ -280,8 +439,10 @@ func ReCompile(pkgs []*dag.Package) bool {
 
 }
 
-//TODO rewrite the whole link stuff, make i run in parallel
-func ForkLinkAll(pkgs []*dag.Package, up2date bool) {
+// link all main packages in parallel, at most -jobs at a time,
+// binaries are named after the directory of the main package, false
+// if some binary could not be linked
+func ForkLinkAll(bindir string, pkgs []*dag.Package, up2date bool) bool {
 
     mainPkgs := make([]*dag.Package, 0)
 
@@ -292,10 +453,15 @@ func ForkLinkAll(pkgs []*dag.Package, up2date bool) {
     }
 
     if len(mainPkgs) == 0 {
-        log.Fatal("[ERROR] (linking) no main package found\n")
+        log.Print("[ERROR] (linking) no main package found\n")
+        return false
     }
 
-    handy.DirOrMkdir("bin")
+    handy.DirOrMkdir(bindir)
+
+    wg := new(sync.WaitGroup)
+    slots := semaphore.New(global.GetInt("-jobs"))
+    failed := make(chan bool, len(mainPkgs))
 
     for i := 0; i < len(mainPkgs); i++ {
         toks := strings.Split(mainPkgs[i].Name, "/")
@@ -304,14 +470,29 @@ func ForkLinkAll(pkgs []*dag.Package, up2date bool) {
         // lives under the src-root and cannot be filtered
         if len(toks) >= 2 {
             nameOfBinary := toks[len(toks)-2]
-            pathToBinary := filepath.Join("bin", nameOfBinary)
-            global.SetString("-main", nameOfBinary)
-            ForkLink(pathToBinary, pkgs, nil, up2date)
+            if handy.GOOS() == "windows" {
+                nameOfBinary += ".exe"
+            }
+            pathToBinary := filepath.Join(bindir, nameOfBinary)
+            wg.Add(1)
+            go func(mainPKG *dag.Package) {
+                slots.Acquire()
+                if !forkLink(pathToBinary, mainPKG, pkgs, nil, up2date) {
+                    failed <- true
+                }
+                slots.Release()
+                wg.Done()
+            }(mainPkgs[i])
         }
     }
+
+    wg.Wait()
+
+    return len(failed) == 0
 }
 
-func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date bool) {
+// false if output could not be linked
+func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date bool) bool {
 
     var mainPKG *dag.Package
 
@@ -324,7 +505,8 @@ func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date
     }
 
     if len(gotMain) == 0 {
-        log.Fatal("[ERROR] (linking) no main package found\n")
+        log.Print("[ERROR] (linking) no main package found\n")
+        return false
     }
 
     if len(gotMain) > 1 {
@@ -334,24 +516,38 @@ func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date
         mainPKG = gotMain[0]
     }
 
-    compiled := filepath.Join(libroot, mainPKG.Name) + suffix
+    return forkLink(output, mainPKG, pkgs, extra, up2date)
+}
+
+// this may run in parallel (ForkLinkAll), i.e. no writes to global
+func forkLink(output string, mainPKG *dag.Package, pkgs []*dag.Package, extra []*dag.Package, up2date bool) bool {
+
+    compiled := filepath.Join(libroot, mainPKG.Stem()) + suffix
 
     if up2date && !global.GetBool("-dryrun") && handy.IsFile(output) {
         if handy.ModifyTimestamp(compiled) < handy.ModifyTimestamp(output) {
             say.Printf("up 2 date: %s\n", output)
-            return
+            event.Emit(&event.Event{Action: "up2date",
+                Package: mainPKG.Name, Output: output})
+            return true
         }
     }
 
+    var importcfg string // go backend, removed after linking
+
     argv := make([]string, 0)
     argv = append(argv, pathLinker)
 
+    if global.GetString("-backend") == "go" {
+        argv, importcfg = goLinkArgv(output, compiled)
+    }
+
     switch global.GetString("-backend") {
     case "gc", "express":
         argv = append(argv, "-L")
         argv = append(argv, libroot)
         if global.GetString("-backend") == "gc" {
-            golibs := handy.GoPathImports("gc")
+            golibs := goPathImports("gc")
             for j := 0; j < len(golibs); j++ {
                 argv = append(argv, "-L")
                 argv = append(argv, golibs[j])
@@ -362,8 +558,10 @@ func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date
         }
     }
 
-    argv = append(argv, "-o")
-    argv = append(argv, output)
+    if global.GetString("-backend") != "go" {
+        argv = append(argv, "-o")
+        argv = append(argv, output)
+    }
 
     // static only for non-gcc
     if global.GetString("-backend") == "gc" &&
@@ -373,14 +571,7 @@ func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date
 
     switch global.GetString("-backend") {
     case "gccgo", "gcc":
-        walker.IncludeFile = func(s string) bool {
-            return strings.HasSuffix(s, ".o")
-        }
-        walker.IncludeDir = func(s string) bool { return true }
-
-        for y := 0; y < len(includes); y++ {
-            argv = append(argv, walker.PathWalk(includes[y])...)
-        }
+        argv = append(argv, includedObjects()...)
     case "gc", "express":
         for y := 0; y < len(includes); y++ {
             argv = append(argv, "-L")
@@ -388,7 +579,9 @@ func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date
         }
     }
 
-    argv = append(argv, compiled)
+    if global.GetString("-backend") != "go" {
+        argv = append(argv, compiled)
+    }
 
     if global.GetString("-backend") == "gcc" ||
         global.GetString("-backend") == "gccgo" {
@@ -399,12 +592,12 @@ func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date
             for j := 0; j < len(extra); j++ {
                 // main package untestable using GCC
                 if extra[j].ShortName != "main" {
-                    ss.Add(filepath.Join(libroot, extra[j].Name) + suffix)
+                    ss.Add(filepath.Join(libroot, extra[j].Stem()) + suffix)
                 }
             }
         } else {
             for k := 0; k < len(pkgs); k++ {
-                ss.Add(filepath.Join(libroot, pkgs[k].Name) + suffix)
+                ss.Add(filepath.Join(libroot, pkgs[k].Stem()) + suffix)
             }
             ss.Remove(compiled)
         }
@@ -416,11 +609,48 @@ func ForkLink(output string, pkgs []*dag.Package, extra []*dag.Package, up2date
 
     if global.GetBool("-dryrun") {
         linker := filepath.Base(pathLinker)
-        fmt.Printf("%s %s || exit 1\n", linker, strings.Join(argv[1:], " "))
+        event.Dryrun(&event.Event{Package: mainPKG.Name, Output: output, Argv: argv},
+            fmt.Sprintf("%s %s || exit 1\n", linker, strings.Join(argv[1:], " ")))
     } else {
         say.Println("linking  :", output)
-        handy.StdExecve(argv, true)
+        linked := true
+        if event.Enabled() {
+            e := &event.Event{Action: "link",
+                Package: mainPKG.Name, Output: output, Argv: argv}
+            linked = event.RunTool(e)
+        } else {
+            linked = handy.ToolExecve(argv, false)
+        }
+        if importcfg != "" {
+            removeImportcfg(importcfg)
+        }
+        if !linked {
+            log.Printf("[ERROR] failed to link: %s\n", output)
+            return false
+        }
     }
+
+    return true
+}
+
+var walkLock = new(sync.Mutex)
+
+// object files (.o) found in -I directories (gccgo)
+func includedObjects() (objects []string) {
+
+    walkLock.Lock()
+    defer walkLock.Unlock()
+
+    walker.IncludeFile = func(s string) bool {
+        return strings.HasSuffix(s, ".o")
+    }
+    walker.IncludeDir = func(s string) bool { return true }
+
+    for y := 0; y < len(includes); y++ {
+        objects = append(objects, walker.PathWalk(includes[y])...)
+    }
+
+    return objects
 }
 
 func mainChoice(pkgs []*dag.Package) int {
@@ -467,6 +697,28 @@ func mainChoice(pkgs []*dag.Package) int {
 }
 
 func CreateTestArgv() []string {
+    return TestArgv(global.GetString("-test-bin"))
+}
+
+// interesting inputs found while fuzzing are kept here
+func fuzzCacheDir() string {
+
+    cache, e := os.UserCacheDir()
+
+    if e != nil {
+        cache = os.TempDir()
+    }
+
+    return filepath.Join(cache, "godag", "fuzz")
+}
+
+// run test binary with the --test.* flags we were given
+func TestArgv(binary string) []string {
+    return testArgv(binary, global.GetString("-coverprofile"))
+}
+
+// profile: where to write the coverage profile, "" for nowhere
+func testArgv(binary, profile string) []string {
 
     pwd, e := os.Getwd()
 
@@ -474,6 +726,10 @@ func CreateTestArgv() []string {
         log.Fatal("[ERROR] could not locate working directory\n")
     }
 
+    if !filepath.IsAbs(binary) {
+        binary = filepath.Join(pwd, binary)
+    }
+
     argv := make([]string, 0)
 
     if global.GetString("-backend") == "express" {
@@ -484,7 +740,7 @@ func CreateTestArgv() []string {
         argv = append(argv, vmrun)
     }
 
-    argv = append(argv, filepath.Join(pwd, global.GetString("-test-bin")))
+    argv = append(argv, binary)
 
     if global.GetString("-bench") != "" {
         argv = append(argv, "-test.bench")
@@ -502,6 +758,25 @@ func CreateTestArgv() []string {
         argv = append(argv, global.GetString("-test.run"))
     }
 
+    if profile != "" {
+        if !filepath.IsAbs(profile) {
+            profile = filepath.Join(pwd, profile)
+        }
+        argv = append(argv, "-test.coverprofile")
+        argv = append(argv, profile)
+    }
+
+    if global.GetString("-fuzz") != "" {
+        argv = append(argv, "-test.fuzz")
+        argv = append(argv, global.GetString("-fuzz"))
+        argv = append(argv, "-test.fuzzcachedir")
+        argv = append(argv, fuzzCacheDir())
+        if global.GetString("-fuzztime") != "" {
+            argv = append(argv, "-test.fuzztime")
+            argv = append(argv, global.GetString("-fuzztime"))
+        }
+    }
+
     if global.GetString("-test.timeout") != "" {
         argv = append(argv, "-test.timeout")
         argv = append(argv, global.GetString("-test.timeout"))
@@ -550,7 +825,6 @@ func CreateTestArgv() []string {
 
 func FormatFiles(files []string) {
 
-    var i int
     var argv []string
     var tabWidth string = "-tabwidth=4"
     var useTabs string = "-tabs=false"
@@ -582,35 +856,60 @@ func FormatFiles(files []string) {
         argv = append(argv, fmt.Sprintf("-r='%s'", rewRule))
     }
 
-    argv = append(argv, "") // dummy
-    i = len(argv) - 1
+    wg := new(sync.WaitGroup)
+    slots := semaphore.New(global.GetInt("-jobs"))
 
     for y := 0; y < len(files); y++ {
-        argv[i] = files[y]
+        fargv := make([]string, len(argv), len(argv)+1)
+        copy(fargv, argv)
+        fargv = append(fargv, files[y])
         if global.GetBool("-dryrun") {
-            argv[0] = filepath.Base(argv[0])
-            fmt.Printf(" %s\n", strings.Join(argv, " "))
+            event.Dryrun(&event.Event{Argv: fargv},
+                fmt.Sprintf(" %s %s\n", filepath.Base(fargv[0]), strings.Join(fargv[1:], " ")))
         } else {
-            say.Printf("gofmt: %s\n", files[y])
-            _ = handy.StdExecve(argv, true)
+            wg.Add(1)
+            go func(fargv []string) {
+                slots.Acquire()
+                say.Printf("gofmt: %s\n", fargv[len(fargv)-1])
+                _ = handy.StdExecve(fargv, true)
+                slots.Release()
+                wg.Done()
+            }(fargv)
         }
     }
+
+    wg.Wait()
+}
+
+// remove dir and its parents as long as they are empty, stop at root
+func removeEmptyParents(dir, root string) {
+    for dir != root && strings.HasPrefix(dir, root) {
+        if os.Remove(dir) != nil {
+            return
+        }
+        dir = filepath.Dir(dir)
+    }
+}
+
+// -vendor-only => no $GOPATH
+func goPathImports(backend string) []string {
+    if global.GetBool("-vendor-only") {
+        return nil
+    }
+    return handy.GoPathImports(backend)
 }
 
 func DeleteObjects(dir string, pkgs []*dag.Package) {
 
     var stub, tmp string
 
-    suffixes := []string{".8", ".6", ".5", ".o", ".vmo"}
+    suffixes := []string{".8", ".6", ".5", ".o", ".vmo", ".a", ".importcfg"}
 
-    libdir := global.GetString("-lib")
-
-    if libdir != "" {
-        dir = libdir
-    }
+    srcdir := dir
+    dir = libDir(srcdir)
 
     for i := 0; i < len(pkgs); i++ {
-        stub = filepath.Join(dir, pkgs[i].Name)
+        stub = filepath.Join(dir, pkgs[i].Stem())
         for j := 0; j < len(suffixes); j++ {
             tmp = stub + suffixes[j]
             if handy.IsFile(tmp) {
@@ -619,23 +918,59 @@ func DeleteObjects(dir string, pkgs []*dag.Package) {
                 } else {
                     say.Printf("rm: %s\n", tmp)
                     handy.Delete(tmp, false)
+                    event.Emit(&event.Event{Action: "rm",
+                        Package: pkgs[i].Name, Output: tmp})
                 }
             }
         }
+        // cgo intermediate files: .name.cgo, --cover: .name.cover
+        for _, ext := range []string{".cgo", ".cover"} {
+            tmp = filepath.Join(filepath.Dir(stub), "."+filepath.Base(stub)+ext)
+            if handy.IsDir(tmp) {
+                if global.GetBool("-dryrun") {
+                    say.Printf("[dryrun] rm: %s\n", tmp)
+                } else {
+                    say.Printf("rm: %s\n", tmp)
+                    handy.RmRf(tmp, false)
+                    event.Emit(&event.Event{Action: "rm",
+                        Package: pkgs[i].Name, Output: tmp})
+                }
+            }
+        }
+        // vendor/required modules (github.com/..) leave empty directories
+        if pkgs[i].Foreign() && !global.GetBool("-dryrun") {
+            removeEmptyParents(filepath.Dir(stub), dir)
+        }
+    }
+
+    tmp = filepath.Join(dir, stateName)
+    if handy.IsFile(tmp) {
+        if global.GetBool("-dryrun") {
+            say.Printf("[dryrun] rm: %s\n", tmp)
+        } else {
+            say.Printf("rm: %s\n", tmp)
+            handy.Delete(tmp, false)
+            event.Emit(&event.Event{Action: "rm", Output: tmp})
+        }
     }
 
     // remove entire dir if empty after objects are deleted.
-    // only do this if -lib is present, there is no reason to
+    // only do this if -lib/target is present, there is no reason to
     // do this (extra treewalk) if objects are in src directory
-    if libdir != "" && handy.IsDir(dir) {
+    if dir != srcdir && handy.IsDir(dir) {
+        // the next target (--goos/--goarch) walks src with these
+        includeFile, includeDir := walker.IncludeFile, walker.IncludeDir
         walker.IncludeFile = func(s string) bool { return true }
         walker.IncludeDir = func(s string) bool { return true }
-        if len(walker.PathWalk(dir)) == 0 {
+        empty := len(walker.PathWalk(dir)) == 0
+        walker.IncludeFile, walker.IncludeDir = includeFile, includeDir
+        if empty {
             if global.GetBool("-dryrun") {
-                fmt.Printf("[dryrun] rm: %s\n", dir)
+                say.Printf("[dryrun] rm: %s\n", dir)
             } else {
                 say.Printf("rm: %s\n", dir)
                 handy.RmRf(dir, true) // die on error
+                event.Emit(&event.Event{Action: "rm", Output: dir})
             }
         }
     }
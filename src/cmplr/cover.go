@@ -0,0 +1,110 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+    "cmplr/dag"
+    "fmt"
+    "log"
+    "path/filepath"
+    "strings"
+    "utilz/handy"
+)
+
+// --cover: packages are compiled from copies of their source where
+// 'go tool cover' has added a counter to each block of statements.
+// Each file gets a counter variable (GoCover_N), the generated test
+// main registers them and writes the profile (see dag/testmain.go).
+// The copies live next to the object file: .name.cover
+
+// source files as they were before Instrument
+var original = make(map[*dag.Package][]string)
+
+func coverDir(pkg *dag.Package) string {
+    dir, base := filepath.Split(filepath.Join(libroot, pkg.Stem()))
+    return filepath.Join(dir, "."+base+".cover")
+}
+
+// has to run before CreateArgv/CreateLibArgv
+func Instrument(pkgs []*dag.Package) {
+
+    var gotool string
+
+    for i := 0; i < len(pkgs); i++ {
+
+        if usesCgo(pkgs[i]) {
+            log.Printf("[WARNING] %s: cgo packages are not instrumented\n", pkgs[i].Name)
+            continue
+        }
+
+        if gotool == "" {
+            gotool = findGo()
+        }
+
+        dir := coverDir(pkgs[i])
+        handy.DirOrMkdir(dir)
+
+        files := make([]string, 0)
+        vars := make([]*dag.CoverVar, 0)
+
+        for _, file := range pkgs[i].Files {
+
+            // tests are not measured
+            if strings.HasSuffix(file, "_test.go") {
+                files = append(files, file)
+                continue
+            }
+
+            cv := &dag.CoverVar{
+                File: pkgs[i].Name + "/" + filepath.Base(file),
+                Var:  fmt.Sprintf("GoCover_%d", len(vars)),
+            }
+
+            instrumented := filepath.Join(dir, filepath.Base(file))
+
+            argv := []string{gotool, "tool", "cover", "-mode=set",
+                "-var", cv.Var, "-o", instrumented, file}
+
+            _, stderr, _, e := handy.Capture(argv)
+
+            if e != nil {
+                log.Fatalf("[ERROR] %s: go tool cover: %s\n%s", file, e, stderr)
+            }
+
+            files = append(files, instrumented)
+            vars = append(vars, cv)
+        }
+
+        original[pkgs[i]] = pkgs[i].Files
+        pkgs[i].Files = files
+        pkgs[i].CoverVars = vars
+    }
+}
+
+// back to the real source, true if anything was instrumented
+func Uninstrument() bool {
+
+    for pkg, files := range original {
+        pkg.Files = files
+        pkg.CoverVars = nil
+        handy.RmRf(coverDir(pkg), false)
+    }
+
+    restored := len(original) > 0
+    original = make(map[*dag.Package][]string)
+
+    return restored
+}
@@ -0,0 +1,217 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "utilz/stringbuffer"
+)
+
+// Loop detection: 'loop in dependency graph' is not much help on a
+// large tree, so we find each strongly connected component (Tarjan)
+// and print a concrete import chain inside it, with the file and
+// line of each import along the way.
+
+type tarjan struct {
+    index   int
+    indices map[*Package]int
+    lowlink map[*Package]int
+    onStack map[*Package]bool
+    stack   []*Package
+    loops   [][]*Package
+}
+
+// components with more than one package, or a package importing itself
+func (d Dag) loops() [][]*Package {
+
+    t := &tarjan{
+        indices: make(map[*Package]int),
+        lowlink: make(map[*Package]int),
+        onStack: make(map[*Package]bool),
+        stack:   make([]*Package, 0),
+        loops:   make([][]*Package, 0),
+    }
+
+    pkgs := d.sortedPackages()
+
+    for i := 0; i < len(pkgs); i++ {
+        if _, seen := t.indices[pkgs[i]]; !seen {
+            t.connect(pkgs[i])
+        }
+    }
+
+    return t.loops
+}
+
+func (t *tarjan) connect(p *Package) {
+
+    t.indices[p] = t.index
+    t.lowlink[p] = t.index
+    t.index++
+    t.stack = append(t.stack, p)
+    t.onStack[p] = true
+
+    for _, dep := range p.locals {
+        if _, seen := t.indices[dep]; !seen {
+            t.connect(dep)
+            if t.lowlink[dep] < t.lowlink[p] {
+                t.lowlink[p] = t.lowlink[dep]
+            }
+        } else if t.onStack[dep] && t.indices[dep] < t.lowlink[p] {
+            t.lowlink[p] = t.indices[dep]
+        }
+    }
+
+    if t.lowlink[p] != t.indices[p] {
+        return
+    }
+
+    component := make([]*Package, 0)
+
+    for {
+        top := t.stack[len(t.stack)-1]
+        t.stack = t.stack[:len(t.stack)-1]
+        t.onStack[top] = false
+        component = append(component, top)
+        if top == p {
+            break
+        }
+    }
+
+    if len(component) > 1 || p.importsItself() {
+        sort.Sort(byName(component))
+        t.loops = append(t.loops, component)
+    }
+}
+
+func (p *Package) importsItself() bool {
+    for _, dep := range p.locals {
+        if dep == p {
+            return true
+        }
+    }
+    return false
+}
+
+// shortest import chain from p back to p, staying inside component
+func importCycle(p *Package, component []*Package) []*Package {
+
+    inside := make(map[*Package]bool)
+    for i := 0; i < len(component); i++ {
+        inside[component[i]] = true
+    }
+
+    prev := make(map[*Package]*Package)
+    queue := []*Package{p}
+
+    for len(queue) > 0 {
+
+        node := queue[0]
+        queue = queue[1:]
+
+        for _, dep := range sortedLocals(node) {
+            if !inside[dep] {
+                continue
+            }
+            if dep == p {
+                chain := []*Package{p}
+                for n := node; n != p; n = prev[n] {
+                    chain = append(chain, n)
+                }
+                chain = append(chain, p)
+                // chain is backwards: p <- .. <- p
+                for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+                    chain[i], chain[j] = chain[j], chain[i]
+                }
+                return chain
+            }
+            if _, seen := prev[dep]; !seen {
+                prev[dep] = node
+                queue = append(queue, dep)
+            }
+        }
+    }
+
+    return nil
+}
+
+func sortedLocals(p *Package) []*Package {
+    locals := make([]*Package, len(p.locals))
+    copy(locals, p.locals)
+    sort.Sort(byName(locals))
+    return locals
+}
+
+// where does 'from' import 'to'
+func (from *Package) importOf(to *Package) *Import {
+    specs := from.importsOf(to.Name)
+    for written, name := range from.importMap {
+        if name == to.Name {
+            specs = append(specs, from.importsOf(written)...)
+        }
+    }
+    if len(specs) == 0 {
+        return &Import{Path: to.Name}
+    }
+    return specs[0]
+}
+
+// "" unless the graph has loops, i.e. Topsort would fail
+func (d Dag) LoopReport() string {
+
+    sb := stringbuffer.New()
+    loops := d.loops()
+
+    for i := 0; i < len(loops); i++ {
+
+        sb.Add(fmt.Sprintf("\n packages in loop: %s\n\n",
+            strings.Join(packageNames(loops[i]), ", ")))
+
+        chain := importCycle(loops[i][0], loops[i])
+
+        if chain == nil {
+            continue
+        }
+
+        sb.Add(fmt.Sprintf("  %s\n\n", strings.Join(packageNames(chain), " -> ")))
+
+        for j := 0; j < len(chain)-1; j++ {
+            imprt := chain[j].importOf(chain[j+1])
+            sb.Add(fmt.Sprintf("  %s: %s\n", imprt.Where(), imprt))
+        }
+    }
+
+    return sb.String()
+}
+
+func packageNames(pkgs []*Package) []string {
+    names := make([]string, len(pkgs))
+    for i := 0; i < len(pkgs); i++ {
+        names[i] = pkgs[i].Name
+    }
+    return names
+}
+
+func (d Dag) sortedPackages() []*Package {
+    pkgs := make([]*Package, 0, len(d))
+    for _, v := range d {
+        pkgs = append(pkgs, v)
+    }
+    sort.Sort(byName(pkgs))
+    return pkgs
+}
@@ -21,33 +21,66 @@ import (
     "go/parser"
     "go/token"
     "log"
+    "io/ioutil"
     "os"
+    "parse/gomod"
+    "parse/tags"
     "path/filepath"
     "regexp"
+    "sort"
     "strings"
     "sync"
     "time"
+    "unicode"
+    "utilz/event"
     "utilz/global"
     "utilz/handy"
     "utilz/say"
+    "utilz/semaphore"
     "utilz/stringbuffer"
     "utilz/stringset"
 )
 
 var locker = new(sync.Mutex)
 var oldPkgFound bool // false
+var module *gomod.Module // nil => no go.mod, layout decides names
 
 type Dag map[string]*Package // package-name -> Package object
 
+// Package.Status after a compile run
+const (
+    Pending = iota
+    Up2date
+    Built
+    Failed
+    Skipped // some local dependency failed or was skipped
+)
+
 type Package struct {
     Indegree        int
+    Status          int
     Name, ShortName string   // absolute path, basename
     Argv            []string // command needed to compile package
+    Pre, Post       [][]string // commands run before/after Argv (cgo)
+    Output          string   // object file produced by Argv
+    Inputs          []string // files besides Files read by Argv
     Files           []string // relative path of files
+    Module          string   // path@version of required module, "" if local
+    Vendor          bool     // lives in src-root/vendor
+    stem            string   // name relative to src root, see Stem()
+    dir             string   // import path of the source directory
+    srcdir          string   // directory holding the source, see SrcDir()
+    testOf          *Package // package under test, if this is package x_test
+    importMap       map[string]string // import as written => package name
+    CoverVars       []*CoverVar // counters of an instrumented package (--cover)
     dependencies    *stringset.StringSet
     children        []*Package // packages that depend on this
+    locals          []*Package // local packages this depends on
+    imports         []*Import  // import declarations, see imports.go
+    fprint          string     // fingerprint, see state.go
     waiter          *sync.WaitGroup
     needsCompile    bool
+    broken          bool // a dependency failed (-keep-going)
     lock            *sync.Mutex
 }
 
@@ -55,6 +88,24 @@ type TestCollector struct {
     TestFuncs    []string
     BenchFuncs   []string
     ExampleFuncs []string
+    FuzzFuncs    []string
+    Examples     []*Example // ExampleFuncs with an output comment
+    TestMain     bool       // func TestMain(m *testing.M)
+    comments     []*ast.CommentGroup
+}
+
+// counter variable 'go tool cover' added to a file of a package
+type CoverVar struct {
+    File string // as named in the coverprofile: package/file.go
+    Var  string
+}
+
+// an example is run and its output checked if the last comment
+// inside the function starts with 'Output:' or 'Unordered output:'
+type Example struct {
+    Name      string
+    Output    string
+    Unordered bool
 }
 
 type initCollector struct {
@@ -69,8 +120,11 @@ func newPackage() *Package {
     p := new(Package)
     p.Indegree = 0
     p.Files = make([]string, 0)
+    p.Inputs = make([]string, 0)
     p.dependencies = stringset.New()
     p.children = make([]*Package, 0)
+    p.locals = make([]*Package, 0)
+    p.imports = make([]*Import, 0)
     p.waiter = nil
     p.needsCompile = false // yeah yeah..
     p.lock = new(sync.Mutex)
@@ -82,21 +136,30 @@ func newTestCollector() *TestCollector {
     t.TestFuncs = make([]string, 0)
     t.BenchFuncs = make([]string, 0)
     t.ExampleFuncs = make([]string, 0)
+    t.FuzzFuncs = make([]string, 0)
+    t.Examples = make([]*Example, 0)
     return t
 }
 
+// local package names get the module path as prefix, and imports
+// from required modules are found in the module cache
+func SetModule(m *gomod.Module) {
+    module = m
+}
+
 func (d Dag) Parse(root string, files []string) {
 
     root = addSeparatorPath(root)
 
     var e, pkgname string
 
+    parsed := parseAll(root, files)
+
     for i := 0; i < len(files); i++ {
         e = files[i]
-        tree := getSyntaxTreeOrDie(e, parser.ImportsOnly)
         dir, _ := filepath.Split(e)
         unroot := dir[len(root):len(dir)]
-        shortname := tree.Name.String()
+        shortname := parsed[i].Package
 
         // if package name == directory name -> assume stdlib organizing
         if len(unroot) > 1 && filepath.Base(dir) == shortname {
@@ -107,23 +170,229 @@ func (d Dag) Parse(root string, files []string) {
 
         pkgname = filepath.ToSlash(pkgname)
 
-        _, ok := d[pkgname]
-        if !ok {
-            d[pkgname] = newPackage()
-            d[pkgname].Name = pkgname
-            d[pkgname].ShortName = shortname
+        // vendor/github.com/a/b => github.com/a/b, the directory is
+        // the import path whatever the package is called
+        if vendored := filepath.ToSlash(unroot); strings.HasPrefix(vendored, "vendor/") &&
+            len(vendored) > len("vendor/") {
+            pkgname = strings.TrimSuffix(strings.TrimPrefix(vendored, "vendor/"), "/")
+            d.addFile(pkgname, shortname, e, parsed[i].Imports)
+            d[pkgname].stem = pkgname
+            d[pkgname].dir = pkgname
+            d[pkgname].Vendor = true
+            continue
+        }
+
+        stem := pkgname
+
+        // modules: the directory is the import path, main and
+        // external test packages keep their name as a suffix
+        if module != nil {
+            pkgname = module.ImportPath(dir)
+            if shortname == "main" || strings.HasSuffix(shortname, "_test") {
+                pkgname = pkgname + "/" + shortname
+            }
         }
 
-        ast.Walk(d[pkgname], tree)
-        d[pkgname].Files = append(d[pkgname].Files, e)
+        d.addFile(pkgname, shortname, e, parsed[i].Imports)
+        d[pkgname].stem = stem
+        d[pkgname].dir = strings.TrimSuffix(filepath.ToSlash(unroot), "/")
+        if module != nil {
+            d[pkgname].dir = module.ImportPath(dir)
+        }
     }
+
+    d.attachTests()
 }
 
+func (d Dag) addFile(pkgname, shortname, file string, imports []*Import) {
+
+    _, ok := d[pkgname]
+    if !ok {
+        d[pkgname] = newPackage()
+        d[pkgname].Name = pkgname
+        d[pkgname].ShortName = shortname
+    }
+
+    d[pkgname].addImports(imports)
+    d[pkgname].Files = append(d[pkgname].Files, file)
+    d[pkgname].srcdir = filepath.Dir(file)
+}
+
+// external test packages (package foo_test) belong to the package
+// foo living in the same directory. they import it by the import path
+// of the directory, which need not be the name godag gives it (dir a
+// with package b is 'a/b'); such imports are mapped to the package
+func (d Dag) attachTests() {
+
+    for _, v := range d {
+
+        if !v.IsExternalTest() {
+            continue
+        }
+
+        v.testOf = d.packageUnderTest(v)
+
+        if v.testOf == nil || v.dir == "" || v.dir == v.testOf.Name {
+            continue
+        }
+
+        if _, ok := d[v.dir]; !ok && v.dependencies.Contains(v.dir) {
+            v.dependencies.Remove(v.dir)
+            v.dependencies.Add(v.testOf.Name)
+            v.importMap = map[string]string{v.dir: v.testOf.Name}
+        }
+    }
+}
+
+// foo for foo_test, or the only other package in the directory
+func (d Dag) packageUnderTest(xtest *Package) *Package {
+
+    var others []*Package
+
+    dir := xtest.SrcDir()
+    name := strings.TrimSuffix(xtest.ShortName, "_test")
+
+    for _, v := range d {
+        if v == xtest || v.IsExternalTest() || v.ShortName == "main" {
+            continue
+        }
+        if v.SrcDir() != dir {
+            continue
+        }
+        if v.ShortName == name {
+            return v
+        }
+        others = append(others, v)
+    }
+
+    if len(others) == 1 {
+        return others[0]
+    }
+
+    return nil
+}
+
+// add packages from required modules ($GOMODCACHE) imported by
+// the packages we have, and the packages they import, and so on..
+func (d Dag) ParseRequired() {
+
+    if module == nil {
+        return
+    }
+
+    for {
+
+        missing := stringset.New()
+
+        for _, v := range d {
+            for dep := range v.dependencies.Iter() {
+                if !d.localDependency(dep) &&
+                    module.Classify(dep) == gomod.Required {
+                    missing.Add(dep)
+                }
+            }
+        }
+
+        if missing.Len() == 0 {
+            return
+        }
+
+        imports := missing.Slice()
+        sort.Strings(imports)
+
+        for i := 0; i < len(imports); i++ {
+            d.parseRequired(imports[i])
+        }
+    }
+}
+
+func (d Dag) parseRequired(imprt string) {
+
+    dir, ok := module.PackageDir(imprt)
+
+    if !ok {
+        log.Fatalf("[ERROR] %s: not in module cache: %s\n", imprt, dir)
+    }
+
+    entries, e := ioutil.ReadDir(dir)
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    r := module.Require(imprt)
+
+    for i := 0; i < len(entries); i++ {
+
+        name := entries[i].Name()
+
+        if entries[i].IsDir() || !strings.HasSuffix(name, ".go") ||
+            strings.HasSuffix(name, "_test.go") {
+            continue
+        }
+
+        pathname := filepath.Join(dir, name)
+
+        if !tags.Match(pathname) {
+            continue
+        }
+
+        tree, fset := getSyntaxTreeAndFileSetOrDie(pathname, parser.ImportsOnly)
+
+        d.addFile(imprt, tree.Name.String(), pathname, fileImports(tree, fset))
+        d[imprt].Module = r.Path + "@" + r.Version
+    }
+
+    if !d.localDependency(imprt) {
+        log.Fatalf("[ERROR] %s: no Go files in %s\n", imprt, dir)
+    }
+}
+
+// true if packages from required modules or vendor/ are part of
+// the build, their objects are placed according to import path
+func (d Dag) HasForeign() bool {
+    for _, v := range d {
+        if v.Foreign() {
+            return true
+        }
+    }
+    return false
+}
+
+// vendor/ + required modules, i.e. not our code
+func (p *Package) Foreign() bool {
+    return p.Module != "" || p.Vendor
+}
+
+// -vendor-only: every import must be local (vendor/), or stdlib
+func (d Dag) CheckVendored() {
+
+    sb := stringbuffer.New()
+
+    for _, v := range d.sortedPackages() {
+        for _, dep := range v.Imports() {
+            if d.localDependency(dep) || gomod.IsStd(dep) {
+                continue
+            }
+            if module != nil && module.Classify(dep) == gomod.Local {
+                continue
+            }
+            for _, imprt := range v.importsOf(dep) {
+                sb.Add(fmt.Sprintf("  %s: %s\n", imprt.Where(), imprt))
+            }
+        }
+    }
+
+    if sb.Len() > 0 {
+        log.Fatalf("[ERROR] not vendored (-vendor-only)\n\n%s", sb.String())
+    }
+}
 
 func (d Dag) addEdge(from, to string) {
     fromNode := d[from]
     toNode := d[to]
     fromNode.children = append(fromNode.children, toNode)
+    toNode.locals = append(toNode.locals, fromNode)
     toNode.Indegree++
 }
 
@@ -132,13 +401,18 @@ func (d Dag) addEdge(from, to string) {
 
 func (d Dag) GraphBuilder() {
 
-    for k, v := range d {
-        for dep := range v.dependencies.Iter() {
+    // sorted => edges (children, locals) in the same order every run
+    for _, v := range d.sortedPackages() {
+        for _, dep := range v.Imports() {
             if d.localDependency(dep) {
-                d.addEdge(dep, k)
+                d.addEdge(dep, v.Name)
                 ///fmt.Printf("local:  %s \n", dep);
             }
         }
+        // compiled after the package under test, imported or not
+        if v.testOf != nil && !v.dependencies.Contains(v.testOf.Name) {
+            d.addEdge(v.testOf.Name, v.Name)
+        }
     }
 }
 
@@ -155,7 +429,12 @@ func (d Dag) Alien() (set *stringset.StringSet) {
     }
 
     for u := range set.Iter() {
-        if !seemsExternal(u) {
+        if module != nil {
+            switch module.Classify(u) {
+            case gomod.Local, gomod.Std:
+                set.Remove(u)
+            }
+        } else if !seemsExternal(u) {
             set.Remove(u)
         }
     }
@@ -187,10 +466,14 @@ func (d Dag) External(update bool) {
     i = len(argv)
     argv = append(argv, "dummy")
 
-    for u := range set.Iter() {
+    alien := set.Slice()
+    sort.Strings(alien)
+
+    for _, u := range alien {
         argv[i] = u
         if global.GetBool("-dryrun") {
-            fmt.Printf("%s || exit 1\n", strings.Join(argv, " "))
+            event.Dryrun(&event.Event{Package: u, Argv: argv},
+                fmt.Sprintf("%s || exit 1\n", strings.Join(argv, " ")))
         } else {
             say.Printf("go get: %s\n", u)
             handy.StdExecve(argv, true)
@@ -249,7 +532,7 @@ func (d Dag) MakeDotGraph(filename string) {
 
     sb.Add("digraph depgraph {\n\trankdir=LR;\n")
 
-    for _, v := range d {
+    for _, v := range d.sortedPackages() {
         v.DotGraph(sb)
     }
 
@@ -261,156 +544,8 @@ func (d Dag) MakeDotGraph(filename string) {
 
 }
 
-func (d Dag) MakeMainTest(root string) ([]*Package, string, string) {
-
-    var (
-        i         int
-        lname     string
-        sname     string
-        tmplib    string
-        tmpdir    string
-        tmpstub   string
-        tmpfile   string
-        collector *TestCollector
-    )
-
-    sbImports := stringbuffer.NewSize(300)
-    imprtSet := stringset.New()
-    sbTests := stringbuffer.NewSize(1000)
-    sbBench := stringbuffer.NewSize(1000)
-    sbExample := stringbuffer.NewSize(1000)
-
-    sbImports.Add("\n// autogenerated code\n\n")
-    sbImports.Add("package main\n\n")
-    imprtSet.Add("import \"regexp\"\n")
-    imprtSet.Add("import \"testing\"\n")
-
-    sbTests.Add("\n\nvar tests = []testing.InternalTest{\n")
-    sbBench.Add("\n\nvar benchmarks = []testing.InternalBenchmark{\n")
-    sbExample.Add("\n\nvar examples = []testing.InternalExample{\n")
-
-    for _, v := range d {
-
-        sname = v.ShortName
-        lname = v.ShortName
-
-        collector = newTestCollector()
-
-        if strings.HasSuffix(v.ShortName, "_test") {
-
-            for i = 0; i < len(v.Files); i++ {
-                tree := getSyntaxTreeOrDie(v.Files[i], parser.ParseComments)
-                ast.Walk(collector, tree)
-            }
-
-        } else {
-
-            for i = 0; i < len(v.Files); i++ {
-                if strings.HasSuffix(v.Files[i], "_test.go") {
-                    tree := getSyntaxTreeOrDie(v.Files[i], parser.ParseComments)
-                    ast.Walk(collector, tree)
-                }
-            }
-        }
-
-        if collector.FoundAnything() {
-
-            if hasSlash(v.Name) {
-                lname = removeSlashAndDot(v.Name)
-                imprtSet.Add(fmt.Sprintf("import %s \"%s\"\n", lname, v.Name))
-            } else {
-                imprtSet.Add(fmt.Sprintf("import \"%s\"\n", v.Name))
-            }
-
-            // add tests
-            for i := 0; i < len(collector.TestFuncs); i++ {
-                fn := collector.TestFuncs[i]
-                sbTests.Add(fmt.Sprintf(
-                    "testing.InternalTest{\"%s.%s\", %s.%s },\n",
-                    sname, fn, lname, fn))
-            }
-
-            // add benchmarks
-            for i := 0; i < len(collector.BenchFuncs); i++ {
-                fn := collector.BenchFuncs[i]
-                sbBench.Add(fmt.Sprintf(
-                    "testing.InternalBenchmark{\"%s.%s\", %s.%s },\n",
-                    sname, fn, lname, fn))
-            }
-
-            // add examples ( not really )
-            for i := 0; i < len(collector.ExampleFuncs); i++ {
-                // fn := collector.ExampleFuncs[i] //TODO add comment which seems to be what we compare against..
-                // sbExample.Add(fmt.Sprintf("testing.InternalExample{\"%s.%s\", %s.%s },\n", sname, fn, lname, fn))
-            }
-        }
-
-    }
-
-    sbTests.Add("};\n")
-    sbBench.Add("};\n")
-    sbExample.Add("};\n")
-
-    for im := range imprtSet.Iter() {
-        sbImports.Add(im)
-    }
-
-    sbTotal := stringbuffer.NewSize(sbImports.Len() +
-        sbTests.Len() +
-        sbBench.Len() + 100)
-    sbTotal.Add(sbImports.String())
-    sbTotal.Add(sbTests.String())
-    sbTotal.Add(sbBench.String())
-    sbTotal.Add(sbExample.String())
-
-    sbTotal.Add("func main(){\n")
-    sbTotal.Add("testing.Main(regexp.MatchString, tests, benchmarks, examples);\n}\n\n")
-
-    tmpstub = fmt.Sprintf("tmp%d", time.Now().Unix())
-    tmpdir = filepath.Join(root, tmpstub)
-    if global.GetString("-lib") != "" {
-        tmplib = filepath.Join(global.GetString("-lib"), tmpstub)
-    }
-
-    dir, e1 := os.Stat(tmpdir)
-
-    if e1 == nil && dir.IsDir() {
-        log.Printf("[ERROR] directory: %s already exists\n", tmpdir)
-    } else {
-        e_mk := os.Mkdir(tmpdir, 0777)
-        if e_mk != nil {
-            log.Fatal("[ERROR] failed to create directory for testing")
-        }
-    }
-
-    tmpfile = filepath.Join(tmpdir, "_main.go")
-
-    fil, e2 := os.OpenFile(tmpfile, os.O_WRONLY|os.O_CREATE, 0777)
-
-    if e2 != nil {
-        log.Fatalf("[ERROR] %s\n", e2)
-    }
-
-    n, e3 := fil.WriteString(sbTotal.String())
-
-    if e3 != nil {
-        log.Fatalf("[ERROR] %s\n", e3)
-    } else if n != sbTotal.Len() {
-        log.Fatal("[ERROR] failed to write test")
-    }
-
-    fil.Close()
-
-    p := newPackage()
-    p.Name = filepath.Join(tmpstub, "main")
-    p.ShortName = "main"
-    p.Files = append(p.Files, tmpfile)
-
-    vec := make([]*Package, 1)
-    vec[0] = p
-    return vec, tmpdir, tmplib
-}
-
+// packages ready to go are taken in lexical order, i.e. the same
+// graph gives the same order every time
 func (d Dag) Topsort() []*Package {
 
     var node, child *Package
@@ -419,7 +554,7 @@ func (d Dag) Topsort() []*Package {
     zero := make([]*Package, 0)
     done := make([]*Package, 0)
 
-    for _, v := range d {
+    for _, v := range d.sortedPackages() {
         if v.Indegree == 0 {
             zero = append(zero, v)
         }
@@ -434,7 +569,7 @@ func (d Dag) Topsort() []*Package {
             child = node.children[i]
             child.Indegree--
             if child.Indegree == 0 {
-                zero = append(zero, child)
+                zero = insertSorted(zero, child)
             }
         }
         cnt++
@@ -442,38 +577,164 @@ func (d Dag) Topsort() []*Package {
     }
 
     if cnt < len(d) {
-        log.Fatal("[ERROR] loop in dependency graph\n")
+        log.Fatalf("[ERROR] loop in dependency graph\n%s", d.LoopReport())
     }
 
     return done
 }
 
+// zero is sorted by name, and so is the result
+func insertSorted(zero []*Package, p *Package) []*Package {
+
+    i := sort.Search(len(zero), func(j int) bool {
+        return zero[j].Name >= p.Name
+    })
+
+    zero = append(zero, nil)
+    copy(zero[i+1:], zero[i:])
+    zero[i] = p
+
+    return zero
+}
+
 func (d Dag) localDependency(dep string) bool {
     _, ok := d[dep]
     return ok
 }
 
-func (d Dag) PrintInfo() {
+// packages importing name, sorted; name need not be local
+func (d Dag) Importers(name string) []*Package {
 
-    var i int
+    importers := make([]*Package, 0)
+
+    for _, v := range d.sortedPackages() {
+        if v.dependencies.Contains(name) {
+            importers = append(importers, v)
+        }
+    }
+
+    return importers
+}
+
+func (d Dag) PrintInfo() {
 
     fmt.Println("--------------------------------------")
     fmt.Println("Packages and Dependencies")
     fmt.Println("p = package, f = file, d = dependency ")
     fmt.Println("--------------------------------------\n")
 
-    for k, v := range d {
-        fmt.Println("p ", k)
-        for i = 0; i < len(v.Files); i++ {
-            fmt.Println("f ", v.Files[i])
+    for _, v := range d.sortedPackages() {
+        fmt.Println("p ", v.Name)
+        for _, file := range v.sortedFiles() {
+            fmt.Println("f ", file)
         }
-        for ds := range v.dependencies.Iter() {
-            fmt.Println("d ", ds)
+        for _, imprt := range v.ImportSpecs() {
+            fmt.Printf("d  %s  %s\n", describeImport(imprt), imprt.Where())
         }
         fmt.Println("")
     }
 }
 
+// path + alias kind + (module mode) where it comes from
+func describeImport(imprt *Import) string {
+
+    desc := imprt.Path
+
+    switch imprt.Kind {
+    case Named:
+        desc += " as " + imprt.Name
+    case Dot, Blank, Cgo:
+        desc += " (" + imprt.KindName() + ")"
+    }
+
+    if module != nil && imprt.Kind != Cgo {
+        desc += " (" + importKind(imprt.Path) + ")"
+    }
+
+    return desc
+}
+
+func importKind(imprt string) string {
+    switch module.Classify(imprt) {
+    case gomod.Local:
+        return "local"
+    case gomod.Std:
+        return "std"
+    case gomod.Required:
+        return "module " + module.Require(imprt).Path
+    }
+    return "unknown"
+}
+
+// object file path relative to the lib directory, local packages
+// of a module drop the module path: example.com/m/a/b => a/b
+func (p *Package) Stem() string {
+    if p.stem != "" {
+        return p.stem
+    }
+    return p.Name
+}
+
+// everything this package imports, sorted
+func (p *Package) Imports() []string {
+    imports := p.dependencies.Slice()
+    sort.Strings(imports)
+    return imports
+}
+
+// Files in lexical order, Files itself is left alone
+func (p *Package) sortedFiles() []string {
+    files := make([]string, len(p.Files))
+    copy(files, p.Files)
+    sort.Strings(files)
+    return files
+}
+
+// where the source lives, Files may be elsewhere (--cover)
+func (p *Package) SrcDir() string {
+    return p.srcdir
+}
+
+// package x_test, i.e. black-box tests of package x
+func (p *Package) IsExternalTest() bool {
+    return strings.HasSuffix(p.ShortName, "_test")
+}
+
+// package under test if this is package x_test, else nil
+func (p *Package) TestOf() *Package {
+    return p.testOf
+}
+
+// imports written differently from the package name, see attachTests
+func (p *Package) ImportMap() map[string]string {
+    return p.importMap
+}
+
+// tooltip: where the import lives, dotted edge: only for side effects
+func (p *Package) dotAttributes(dep string) string {
+
+    where := make([]string, 0)
+    sideEffect := true
+
+    for _, imprt := range p.importsOf(dep) {
+        where = append(where, imprt.Where())
+        if imprt.Kind != Blank {
+            sideEffect = false
+        }
+    }
+
+    if len(where) == 0 {
+        return ""
+    }
+
+    attr := fmt.Sprintf(" [tooltip=\"%s\"", strings.Join(where, "\\n"))
+    if sideEffect {
+        attr += ", style=dotted"
+    }
+
+    return attr + "]"
+}
+
 func (p *Package) DotGraph(sb *stringbuffer.StringBuffer) {
 
     if p.dependencies.Len() == 0 {
@@ -482,8 +743,9 @@ func (p *Package) DotGraph(sb *stringbuffer.StringBuffer) {
 
     } else {
 
-        for dep := range p.dependencies.Iter() {
-            sb.Add(fmt.Sprintf("\t\"%s\" -> \"%s\";\n", p.Name, dep))
+        for _, dep := range p.Imports() {
+            sb.Add(fmt.Sprintf("\t\"%s\" -> \"%s\"%s;\n",
+                p.Name, dep, p.dotAttributes(dep)))
         }
     }
 }
@@ -563,10 +825,7 @@ func (p *Package) Rep() string {
     sb = append(sb, "    output: \"_obj/"+p.Name+"\",")
 
     // special case: build from PWD (srcdir == .)
-    files := make([]string, len(p.Files))
-    for i := 0; i < len(p.Files); i++ {
-        files[i] = p.Files[i]
-    }
+    files := p.sortedFiles()
 
     pwd, e := os.Getwd()
     if e == nil {
@@ -595,37 +854,22 @@ func (p *Package) Rep() string {
 
 func (p *Package) UpToDate() bool {
 
+    var i int
+
     if p.Argv == nil {
         log.Fatalf("[ERROR] missing dag.Package.Argv\n")
     }
 
-    var e error
-    var finfo os.FileInfo
-    var compiledModifiedTime int64
-    var last, stop, i int
-    var resultingFile string
-
-    last = len(p.Argv) - 1
-    stop = last - len(p.Files)
-    resultingFile = p.Argv[stop]
-
-    finfo, e = os.Stat(resultingFile)
-
-    if e != nil {
+    if !handy.IsFile(p.Output) {
         return false
-    } else {
-        compiledModifiedTime = finfo.ModTime().UnixNano()
     }
 
-    for i = last; i > stop; i-- {
-        finfo, e = os.Stat(p.Argv[i])
-        if e != nil {
-            panic(fmt.Sprintf("Missing go file: %s\n", p.Argv[i]))
-        } else {
-            if finfo.ModTime().UnixNano() > compiledModifiedTime {
-                return false
-            }
+    if hasState() {
+        if !p.sameFingerprint() {
+            return false
         }
+    } else if p.modifiedSince(handy.ModifyTimestamp(p.Output)) {
+        return false
     }
 
     // package contains _test.go and -test => not UpToDate
@@ -644,6 +888,38 @@ func (p *Package) UpToDate() bool {
     return true
 }
 
+// compare fingerprint with the one stored last time we compiled
+func (p *Package) sameFingerprint() bool {
+
+    var e error
+
+    p.fprint, e = p.fingerprint()
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    fprint, ok := getState(p.Name)
+
+    return ok && fprint == p.fprint
+}
+
+// only used when there is no build state, i.e. single file (gorun)
+func (p *Package) modifiedSince(compiled int64) bool {
+
+    for i := 0; i < len(p.Files); i++ {
+        finfo, e := os.Stat(p.Files[i])
+        if e != nil {
+            panic(fmt.Sprintf("Missing go file: %s\n", p.Files[i]))
+        }
+        if finfo.ModTime().UnixNano() > compiled {
+            return true
+        }
+    }
+
+    return false
+}
+
 func (p *Package) Ready(local, compiled *stringset.StringSet) bool {
 
     for dep := range p.dependencies.Iter() {
@@ -664,60 +940,112 @@ func (p *Package) ResetIndegree() {
 func (p *Package) InitWaitGroup() {
     p.waiter = new(sync.WaitGroup)
     p.waiter.Add(p.Indegree)
+    p.Status = Pending
+    p.broken = false
 }
 
-func (p *Package) Decrement(compile bool) {
+func (p *Package) Decrement(compile, broken bool) {
     p.lock.Lock()
     p.needsCompile = compile
+    if broken {
+        p.broken = true
+    }
     p.waiter.Done()
     p.lock.Unlock()
 }
 
-func (p *Package) Compile(ch chan int) {
+// the waiter makes sure dependencies are compiled first, the
+// slots limit the number of compilers running at the same time
+func (p *Package) Compile(ch chan int, slots semaphore.Semaphore) {
 
     var doCompile bool
 
     p.waiter.Wait()
+    p.fprint = ""
 
-    if p.needsCompile || !p.UpToDate() {
+    if p.broken {
+        p.Status = Skipped
+        say.Printf("skipping : %s\n", p.Name)
+        event.Emit(&event.Event{Action: "skip", Package: p.Name})
+    } else if p.needsCompile || !p.UpToDate() {
         oldPkgIsFound()
         doCompile = true
     } else {
+        p.Status = Up2date
         say.Printf("up 2 date: %s\n", p.Name)
+        event.Emit(&event.Event{Action: "up2date", Package: p.Name})
     }
     if doCompile {
+        slots.Acquire()
         say.Printf("compiling: %s\n", p.Name)
-        handy.StdExecve(p.Argv, true)
+        ok := p.execute()
+        slots.Release()
+        if ok {
+            p.Status = Built
+            p.remember()
+        } else {
+            p.Status = Failed
+        }
     }
     for _, child := range p.children {
-        child.Decrement(doCompile)
+        child.Decrement(doCompile, p.Status == Failed || p.Status == Skipped)
     }
     ch <- 1
 }
 
-func (p *Package) Visit(node ast.Node) (v ast.Visitor) {
+// run compiler, -keep-going: a failed package should not stop the others
+func (p *Package) execute() bool {
 
-    switch node.(type) {
-    case *ast.BasicLit:
-        bl, ok := node.(*ast.BasicLit)
-        if ok {
-            stripped := string(bl.Value[1 : len(bl.Value)-1])
-            p.dependencies.Add(stripped)
+    stop := !global.GetBool("-keep-going")
+    cmds := p.Commands()
+
+    if !event.Enabled() {
+        for i := 0; i < len(cmds); i++ {
+            if !handy.ToolExecve(cmds[i], stop) {
+                return false
+            }
         }
-    default: // nothing to do if not BasicLit
+        return true
     }
-    return p
+
+    event.Emit(&event.Event{Action: "start", Package: p.Name, Argv: p.Argv})
+
+    ok := true
+
+    // finish is the last command, anything before that is a step
+    for i := 0; i < len(cmds) && ok; i++ {
+        action := "step"
+        if i == len(cmds)-1 {
+            action = "finish"
+        }
+        ok = event.RunTool(&event.Event{Action: action, Package: p.Name, Argv: cmds[i]})
+    }
+
+    if !ok && stop {
+        log.Fatalf("[ERROR] failed to compile: %s\n", p.Name)
+    }
+
+    return ok
+}
+
+// every command needed to produce Output, in order
+func (p *Package) Commands() [][]string {
+    cmds := make([][]string, 0, len(p.Pre)+len(p.Post)+1)
+    cmds = append(cmds, p.Pre...)
+    cmds = append(cmds, p.Argv)
+    cmds = append(cmds, p.Post...)
+    return cmds
 }
 
-//TODO make this examples stuff work, if someone asks for it..
-//TODO check that types are ok as well..
 func (t *TestCollector) Visit(node ast.Node) (v ast.Visitor) {
 
     switch fn := node.(type) {
     case *ast.FuncDecl:
 
         if fn.Recv == nil { // node is a function
-            if strings.HasPrefix(fn.Name.Name, "Test") {
+            if fn.Name.Name == "TestMain" && takesTestingM(fn) {
+                t.TestMain = true
+            } else if strings.HasPrefix(fn.Name.Name, "Test") {
                 if fn.Type.Params != nil && fn.Type.Params.NumFields() == 1 {
                     t.TestFuncs = append(t.TestFuncs, fn.Name.Name)
                 }
@@ -727,9 +1055,17 @@ func (t *TestCollector) Visit(node ast.Node) (v ast.Visitor) {
                     t.BenchFuncs = append(t.BenchFuncs, fn.Name.Name)
                 }
             }
+            if strings.HasPrefix(fn.Name.Name, "Fuzz") {
+                if fn.Type.Params != nil && fn.Type.Params.NumFields() == 1 {
+                    t.FuzzFuncs = append(t.FuzzFuncs, fn.Name.Name)
+                }
+            }
             if strings.HasPrefix(fn.Name.Name, "Example") {
                 if fn.Type.Params != nil && fn.Type.Params.NumFields() == 0 {
                     t.ExampleFuncs = append(t.ExampleFuncs, fn.Name.Name)
+                    if example := t.example(fn); example != nil {
+                        t.Examples = append(t.Examples, example)
+                    }
                 }
             }
         }
@@ -741,14 +1077,72 @@ func (t *TestCollector) Visit(node ast.Node) (v ast.Visitor) {
     return t
 }
 
+// TestMain(m *testing.M), not a test taking *testing.T
+func takesTestingM(fn *ast.FuncDecl) bool {
+
+    if fn.Type.Params == nil || fn.Type.Params.NumFields() != 1 {
+        return false
+    }
+
+    star, ok := fn.Type.Params.List[0].Type.(*ast.StarExpr)
+
+    if !ok {
+        return false
+    }
+
+    switch typ := star.X.(type) {
+    case *ast.SelectorExpr:
+        return typ.Sel.Name == "M"
+    case *ast.Ident: // import . "testing"
+        return typ.Name == "M"
+    }
+
+    return false
+}
+
+var outputPrefix = regexp.MustCompile(`(?i)^[[:space:]]*(unordered )?output:`)
+
+// the rules of go/doc: last comment in the body, text after the prefix
+func (t *TestCollector) example(fn *ast.FuncDecl) *Example {
+
+    var last *ast.CommentGroup
+
+    if fn.Body == nil {
+        return nil
+    }
+
+    for _, group := range t.comments {
+        if group.Pos() > fn.Body.Lbrace && group.End() < fn.Body.Rbrace {
+            last = group
+        }
+    }
+
+    if last == nil {
+        return nil
+    }
+
+    text := last.Text()
+    loc := outputPrefix.FindStringSubmatchIndex(text)
+
+    if loc == nil {
+        return nil
+    }
+
+    text = strings.TrimLeft(text[loc[1]:], " ")
+    text = strings.TrimPrefix(text, "\n")
+
+    return &Example{Name: fn.Name.Name, Output: text, Unordered: loc[2] != -1}
+}
+
 func (t *TestCollector) String() string {
-    return fmt.Sprintf("&TestCollector{\n\tt: %v\n\tb: %v\n\te: %v\n}\n",
-        t.TestFuncs, t.BenchFuncs, t.ExampleFuncs)
+    return fmt.Sprintf("&TestCollector{\n\tt: %v\n\tb: %v\n\te: %v\n\tf: %v\n}\n",
+        t.TestFuncs, t.BenchFuncs, t.ExampleFuncs, t.FuzzFuncs)
 }
 
 func (t *TestCollector) FoundAnything() bool {
-    tot := len(t.TestFuncs) + len(t.BenchFuncs) + len(t.ExampleFuncs)
-    return tot > 0
+    tot := len(t.TestFuncs) + len(t.BenchFuncs) +
+        len(t.ExampleFuncs) + len(t.FuzzFuncs)
+    return tot > 0 || t.TestMain
 }
 
 func (i *initCollector) Visit(node ast.Node) (v ast.Visitor) {
@@ -772,21 +1166,92 @@ func addSeparatorPath(root string) string {
     return root
 }
 
-func hasSlash(s string) bool {
-    return strings.Index(s, "/") != -1
+// go-foo/foo_test => gofoofoo_test, usable as an import alias
+func importAlias(s string) string {
+    return strings.Map(func(r rune) rune {
+        if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+            return r
+        }
+        return -1
+    }, s)
 }
 
-func removeSlashAndDot(s string) string {
-    noslash := strings.Replace(s, "/", "", -1)
-    return strings.Replace(noslash, ".", "", -1)
+func getSyntaxTreeOrDie(file string, mode parser.Mode) *ast.File {
+    absSynTree, _ := getSyntaxTreeAndFileSetOrDie(file, mode)
+    return absSynTree
 }
 
-func getSyntaxTreeOrDie(file string, mode parser.Mode) *ast.File {
-    absSynTree, err := parser.ParseFile(token.NewFileSet(), file, nil, mode)
+// fileset is needed to turn positions into file:line
+func getSyntaxTreeAndFileSetOrDie(file string, mode parser.Mode) (*ast.File, *token.FileSet) {
+
+    var fi os.FileInfo
+
+    if keepParsed {
+        fi, _ = os.Stat(file)
+        if p := lookupParsed(file, mode, fi); p != nil {
+            return p.tree, p.fset
+        }
+    }
+
+    fset := token.NewFileSet()
+    absSynTree, err := parser.ParseFile(fset, file, nil, mode)
     if err != nil {
         log.Fatalf("%s\n", err)
     }
-    return absSynTree
+
+    if keepParsed && fi != nil {
+        rememberParsed(file, mode, &parsedFile{
+            size: fi.Size(), mtime: fi.ModTime(), tree: absSynTree, fset: fset})
+    }
+
+    return absSynTree, fset
+}
+
+// --watch: syntax trees are kept until their file changes, the
+// trees are only read, i.e. they can be handed out again
+
+type parsedKey struct {
+    file string
+    mode parser.Mode
+}
+
+type parsedFile struct {
+    size  int64
+    mtime time.Time
+    tree  *ast.File
+    fset  *token.FileSet
+}
+
+var keepParsed bool // false
+var parsed = make(map[parsedKey]*parsedFile)
+var parsedLock = new(sync.Mutex)
+
+func KeepParsed() {
+    keepParsed = true
+}
+
+func lookupParsed(file string, mode parser.Mode, fi os.FileInfo) *parsedFile {
+
+    if fi == nil {
+        return nil
+    }
+
+    parsedLock.Lock()
+    defer parsedLock.Unlock()
+
+    p, ok := parsed[parsedKey{file, mode}]
+
+    if ok && p.size == fi.Size() && p.mtime.Equal(fi.ModTime()) {
+        return p
+    }
+
+    return nil
+}
+
+func rememberParsed(file string, mode parser.Mode, p *parsedFile) {
+    parsedLock.Lock()
+    parsed[parsedKey{file, mode}] = p
+    parsedLock.Unlock()
 }
 
 func OldPkgYet() (res bool) {
@@ -805,8 +1270,8 @@ func oldPkgIsFound() {
 // gorun like stuff
 func ParseSingle(pathname string) (pkgs []*Package, name string) {
 
-    tree      := getSyntaxTreeOrDie(pathname, parser.ImportsOnly)
-    shortname := tree.Name.String()
+    tree, fset := getSyntaxTreeAndFileSetOrDie(pathname, parser.ImportsOnly)
+    shortname  := tree.Name.String()
 
     if shortname != "main" {
         log.Fatalf("[ERROR] running a single file requires 'main' package\n")
@@ -821,6 +1286,8 @@ func ParseSingle(pathname string) (pkgs []*Package, name string) {
     name            = filepath.Join(stub, handy.Sha1(absPath))
     p.Name          = name
     p.Files         = append(p.Files, pathname)
+    p.srcdir        = filepath.Dir(pathname)
+    p.addImports(fileImports(tree, fset))
 
     pkgs = append(pkgs, p)
 
@@ -0,0 +1,139 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// write files (relative name => content) below a new src directory,
+// the parse cache goes to a directory of its own
+func parseTree(t *testing.T, files map[string]string) Dag {
+
+    tmp, e := ioutil.TempDir("", "gdtest")
+
+    if e != nil {
+        t.Fatalf("%s\n", e)
+    }
+
+    t.Cleanup(func() { os.RemoveAll(tmp) })
+    t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+
+    root := filepath.Join(tmp, "src")
+    paths := make([]string, 0, len(files))
+
+    for name, content := range files {
+        path := filepath.Join(root, filepath.FromSlash(name))
+        if e = os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+            t.Fatalf("%s\n", e)
+        }
+        if e = ioutil.WriteFile(path, []byte(content), 0644); e != nil {
+            t.Fatalf("%s\n", e)
+        }
+        paths = append(paths, path)
+    }
+
+    d := New()
+    d.Parse(root, paths)
+
+    return d
+}
+
+func TestParseVendor(t *testing.T) {
+
+    d := parseTree(t, map[string]string{
+        "vendor/github.com/x/go-isatty/isatty.go": "package isatty\n",
+        "vendor/github.com/x/color/color.go":      "package color\n",
+        "app/main.go": "package main\n\nimport \"github.com/x/go-isatty\"\n",
+    })
+
+    // import path => stem
+    vendored := map[string]string{
+        "github.com/x/go-isatty": "github.com/x/go-isatty",
+        "github.com/x/color":     "github.com/x/color",
+    }
+
+    for name, stem := range vendored {
+        p, ok := d[name]
+        if !ok {
+            t.Fatalf("vendored package not found: %s\n", name)
+        }
+        if !p.Vendor || p.Stem() != stem || p.dir != name {
+            t.Fatalf("%s: vendor=%t stem=%s dir=%s\n", name, p.Vendor, p.Stem(), p.dir)
+        }
+    }
+
+    if _, ok := d["github.com/x/go-isatty/isatty"]; ok {
+        t.Fatalf("vendored package named after its package clause\n")
+    }
+
+    if d.Alien().Contains("github.com/x/go-isatty") {
+        t.Fatalf("app/main: import of vendored package not local\n")
+    }
+}
+
+func TestParseImports(t *testing.T) {
+
+    d := parseTree(t, map[string]string{
+        "a/a.go": "package a\n\nimport (\n    \"fmt\"\n    f \"os\"\n" +
+            "    . \"strings\"\n    _ \"net/http\"\n)\n",
+        "a/b.go": "package a\n\n// #include <stdio.h>\nimport \"C\"\n\nimport \"fmt\"\n",
+    })
+
+    p, ok := d["a"]
+
+    if !ok {
+        t.Fatalf("package not found: a\n")
+    }
+
+    // sorted by path, file and line
+    want := []string{
+        `cgo import "C" b.go:4`,
+        `plain import "fmt" a.go:4`,
+        `plain import "fmt" b.go:6`,
+        `blank import _ "net/http" a.go:7`,
+        `named import f "os" a.go:5`,
+        `dot import . "strings" a.go:6`,
+    }
+
+    specs := p.ImportSpecs()
+
+    if len(specs) != len(want) {
+        t.Fatalf("%d import specs != %d\n", len(specs), len(want))
+    }
+
+    for i := 0; i < len(specs); i++ {
+        got := fmt.Sprintf("%s %s %s:%d", specs[i].KindName(), specs[i],
+            filepath.Base(specs[i].Pos.Filename), specs[i].Pos.Line)
+        if got != want[i] {
+            t.Fatalf("import spec %d: '%s' != '%s'\n", i, got, want[i])
+        }
+    }
+
+    // "C" is no dependency, only input for cgo
+    if imports := strings.Join(p.Imports(), " "); imports != "fmt net/http os strings" {
+        t.Fatalf("imports: '%s'\n", imports)
+    }
+
+    if cgo := p.CgoFiles(); len(cgo) != 1 || filepath.Base(cgo[0]) != "b.go" {
+        t.Fatalf("cgo files: %v\n", cgo)
+    }
+}
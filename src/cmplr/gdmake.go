@@ -26,6 +26,7 @@ import (
     "log"
     "os"
     "path/filepath"
+    "sort"
     "strings"
     "time"
     "utilz/handy"
@@ -94,6 +95,7 @@ func Make(fname string, pkgs []*dag.Package, alien []string) {
     sb.Add(m[Playground])
     sb.Add("// PLAYGROUND STOP\n")
     sb.Add(m[Init])
+    sort.Strings(alien)
     for i := 0; i < len(alien); i++ {
         alien[i] = `"` + alien[i] + `"`
     }
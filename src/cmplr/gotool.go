@@ -0,0 +1,352 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+    "cmplr/dag"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "utilz/global"
+    "utilz/handy"
+    "utilz/stringbuffer"
+)
+
+// The 'go' backend: 'go tool compile' and 'go tool link'. These
+// tools do not search directories for imports, every import has to
+// be listed in an importcfg file:
+//
+//  packagefile fmt=/home/me/.cache/go-build/ab/ab12...-d
+//  packagefile cmplr/dag=_obj/cmplr/dag.a
+//
+// Local packages map to the archives we produce (.a), archives found
+// in -I directories are used as is, everything else (stdlib..) is
+// handed to 'go list -export' which compiles it in the go build
+// cache and tells us where the export data ended up.
+
+// import path -> archive/export data
+var objects = make(map[string]string)
+var objectsLock = new(sync.Mutex)
+
+func goTool() {
+
+    pathCompiler = findGo()
+    pathLinker = pathCompiler
+
+    // export data belongs to a target, InitBackend runs for each
+    objectsLock.Lock()
+    objects = make(map[string]string)
+    objectsLock.Unlock()
+
+    suffix = ".a"
+}
+
+// $GOROOT/bin/go or go in $PATH
+func findGo() string {
+
+    path, err := exec.LookPath(filepath.Join(handy.GOROOT(), "bin", "go"))
+
+    if err != nil {
+        path, err = exec.LookPath("go")
+        if err != nil {
+            log.Fatalf("[ERROR] could not find 'go' in $GOROOT/bin or $PATH\n")
+        }
+    }
+
+    return path
+}
+
+// imports 'go list' cannot find => error, i.e. an edit in progress
+// should not stop --watch or --serve
+func createGoArgv(pkgs []*dag.Package) error {
+
+    for y := 0; y < len(pkgs); y++ {
+        pkgs[y].Output = filepath.Join(libroot, pkgs[y].Stem()) + suffix
+    }
+
+    registerLocal(pkgs)
+
+    imports := make([]string, 0)
+    for y := 0; y < len(pkgs); y++ {
+        imports = append(imports, goImports(pkgs[y])...)
+    }
+
+    if e := resolveImports(imports); e != nil {
+        return e
+    }
+
+    for y := 0; y < len(pkgs); y++ {
+        pkgs[y].Argv = goCompileArgv(pkgs[y])
+    }
+
+    return nil
+}
+
+// go tool compile -o x.a -p import/path -pack -importcfg x.importcfg files..
+func goCompileArgv(pkg *dag.Package) []string {
+
+    importcfg := strings.TrimSuffix(pkg.Output, suffix) + ".importcfg"
+
+    importPath := pkg.Name
+    if pkg.ShortName == "main" {
+        importPath = "main"
+    }
+
+    argv := make([]string, 0)
+    argv = append(argv, pathCompiler)
+    argv = append(argv, "tool")
+    argv = append(argv, "compile")
+    argv = append(argv, "-o")
+    argv = append(argv, pkg.Output)
+    argv = append(argv, "-p")
+    argv = append(argv, importPath)
+    argv = append(argv, "-pack")
+    argv = append(argv, "-importcfg")
+    argv = append(argv, importcfg)
+
+    // coverage guided fuzzing, like 'go test -fuzz'
+    if global.GetString("-fuzz") != "" && !pkg.Foreign() {
+        argv = append(argv, "-d=libfuzzer")
+    }
+
+    // -dryrun leaves no trace
+    if !global.GetBool("-dryrun") {
+        writeImportcfg(importcfg, goImports(pkg), pkg.ImportMap())
+    }
+
+    pkg.Inputs = []string{importcfg}
+    pkg.Pre, pkg.Post = nil, nil
+
+    if usesCgo(pkg) {
+        argv = append(argv, cgoArgv(pkg)...)
+    } else {
+        argv = append(argv, pkg.Files...)
+    }
+
+    return argv
+}
+
+// imports + what cgo generated code imports
+func goImports(pkg *dag.Package) []string {
+    if usesCgo(pkg) {
+        return append(pkg.Imports(), cgoImports...)
+    }
+    return pkg.Imports()
+}
+
+// local packages are known before any importcfg is written
+func registerLocal(pkgs []*dag.Package) {
+    objectsLock.Lock()
+    for i := 0; i < len(pkgs); i++ {
+        objects[pkgs[i].Name] = pkgs[i].Output
+    }
+    objectsLock.Unlock()
+}
+
+// find archives for all imports not seen before
+func resolveImports(imports []string) error {
+
+    objectsLock.Lock()
+    defer objectsLock.Unlock()
+
+    unknown := make([]string, 0)
+
+    for i := 0; i < len(imports); i++ {
+        if _, ok := objects[imports[i]]; ok {
+            continue
+        }
+        if imports[i] == "unsafe" {
+            continue
+        }
+        if archive := includedArchive(imports[i]); archive != "" {
+            objects[imports[i]] = archive
+            continue
+        }
+        unknown = append(unknown, imports[i])
+    }
+
+    if len(unknown) == 0 {
+        return nil
+    }
+
+    // runtime + its dependencies are needed by the linker
+    if _, ok := objects["runtime"]; !ok {
+        unknown = append(unknown, "runtime")
+    }
+
+    argv := []string{pathCompiler, "list", "-export", "-deps", "-f",
+        "{{if .Export}}{{.ImportPath}}={{.Export}}{{end}}"}
+    argv = append(argv, unknown...)
+
+    stdout, stderr, _, err := handy.ToolCapture(argv)
+
+    if err != nil {
+        return fmt.Errorf("go list -export: %s\n%s", err, strings.TrimSpace(stderr))
+    }
+
+    lines := strings.Split(stdout, "\n")
+
+    for i := 0; i < len(lines); i++ {
+        kv := strings.SplitN(strings.TrimSpace(lines[i]), "=", 2)
+        if len(kv) == 2 {
+            if _, ok := objects[kv[0]]; !ok {
+                objects[kv[0]] = kv[1]
+            }
+        }
+    }
+
+    return nil
+}
+
+// archive compiled earlier into some -I directory
+func includedArchive(imprt string) string {
+    for i := 0; i < len(includes); i++ {
+        archive := filepath.Join(includes[i], imprt) + suffix
+        if handy.IsFile(archive) {
+            return archive
+        }
+    }
+    return ""
+}
+
+// importmap lines let package x_test import x by the path of its
+// directory, the linker knows nothing about them (importmap == nil)
+func importcfgContent(imports []string, importmap map[string]string) []byte {
+
+    objectsLock.Lock()
+    defer objectsLock.Unlock()
+
+    sort.Strings(imports)
+
+    sb := stringbuffer.New()
+    sb.Add("# import config, generated by godag\n")
+
+    written := make([]string, 0, len(importmap))
+    for k, _ := range importmap {
+        written = append(written, k)
+    }
+    sort.Strings(written)
+
+    for i := 0; i < len(written); i++ {
+        sb.Add("importmap " + written[i] + "=" + importmap[written[i]] + "\n")
+    }
+
+    for i := 0; i < len(imports); i++ {
+        archive, ok := objects[imports[i]]
+        if ok {
+            sb.Add("packagefile " + imports[i] + "=" + archive + "\n")
+        }
+    }
+
+    return sb.Bytes()
+}
+
+// only write if content changed, the file is part of the fingerprint
+func writeImportcfg(pathname string, imports []string, importmap map[string]string) {
+
+    content := importcfgContent(imports, importmap)
+
+    old, e := ioutil.ReadFile(pathname)
+
+    if e == nil && string(old) == string(content) {
+        return
+    }
+
+    e = ioutil.WriteFile(pathname, content, 0644)
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+}
+
+// go tool link -o output -importcfg cfg main.a, the importcfg holds
+// every archive we know of, the linker picks what it needs; the caller
+// removes importcfg, which is not written with -dryrun
+func goLinkArgv(output, compiled string) (argv []string, importcfg string) {
+
+    if global.GetBool("-dryrun") {
+        importcfg = filepath.Join(os.TempDir(), "godag-importcfg")
+    } else {
+        importcfg = writeLinkImportcfg()
+    }
+
+    argv = append(argv, pathLinker)
+    argv = append(argv, "tool")
+    argv = append(argv, "link")
+    argv = append(argv, "-o")
+    argv = append(argv, output)
+    argv = append(argv, "-importcfg")
+    argv = append(argv, importcfg)
+
+    if global.GetBool("-strip") {
+        argv = append(argv, "-s")
+        argv = append(argv, "-w")
+    }
+
+    if global.GetBool("-static") {
+        argv = append(argv, "-extldflags")
+        argv = append(argv, "-static")
+    }
+
+    cgoLock.Lock()
+    if cgoUsed {
+        argv = append(argv, "-extld")
+        argv = append(argv, cCompiler())
+    }
+    cgoLock.Unlock()
+
+    argv = append(argv, compiled)
+
+    return argv, importcfg
+}
+
+func writeLinkImportcfg() string {
+
+    objectsLock.Lock()
+    all := make([]string, 0, len(objects))
+    for k, _ := range objects {
+        all = append(all, k)
+    }
+    objectsLock.Unlock()
+
+    fd, e := ioutil.TempFile("", "godag-importcfg")
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    _, e = fd.Write(importcfgContent(all, nil))
+    fd.Close()
+
+    if e != nil {
+        os.Remove(fd.Name())
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    return fd.Name()
+}
+
+func removeImportcfg(pathname string) {
+    if handy.IsFile(pathname) {
+        os.Remove(pathname)
+    }
+}
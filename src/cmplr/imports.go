@@ -0,0 +1,173 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "fmt"
+    "go/ast"
+    "go/token"
+    "sort"
+    "strconv"
+)
+
+// Each import declaration of a package, as written in the source:
+//
+//  import "fmt"          Plain
+//  import f "fmt"        Named
+//  import . "fmt"        Dot
+//  import _ "net/http"   Blank
+//  import "C"            Cgo (not a package, never a dependency)
+
+const (
+    Plain = iota
+    Named
+    Dot
+    Blank
+    Cgo
+)
+
+type Import struct {
+    Path string
+    Name string // alias, "" unless Named/Dot/Blank
+    Kind int
+    Pos  token.Position
+}
+
+// import declarations of tree, and where they live if fset != nil
+func fileImports(tree *ast.File, fset *token.FileSet) []*Import {
+
+    imports := make([]*Import, 0, len(tree.Imports))
+
+    for _, spec := range tree.Imports {
+
+        path, e := strconv.Unquote(spec.Path.Value)
+
+        if e != nil {
+            continue // parser would not let this through..
+        }
+
+        imprt := &Import{Path: path, Kind: Plain}
+
+        if fset != nil {
+            imprt.Pos = fset.Position(spec.Path.Pos())
+        }
+
+        if spec.Name != nil {
+            imprt.Name = spec.Name.Name
+            switch imprt.Name {
+            case ".":
+                imprt.Kind = Dot
+            case "_":
+                imprt.Kind = Blank
+            default:
+                imprt.Kind = Named
+            }
+        }
+
+        if path == "C" {
+            imprt.Kind = Cgo
+        }
+
+        imports = append(imports, imprt)
+    }
+
+    return imports
+}
+
+// import declarations => dependencies
+func (p *Package) addImports(imports []*Import) {
+    for _, imprt := range imports {
+        if imprt.Kind != Cgo {
+            p.dependencies.Add(imprt.Path)
+        }
+        p.imports = append(p.imports, imprt)
+    }
+}
+
+// all import declarations sorted by path, file and line
+func (p *Package) ImportSpecs() []*Import {
+    specs := make([]*Import, len(p.imports))
+    copy(specs, p.imports)
+    sort.Sort(byPosition(specs))
+    return specs
+}
+
+// import declarations of one path, first one first
+func (p *Package) importsOf(path string) []*Import {
+    specs := make([]*Import, 0)
+    for _, imprt := range p.ImportSpecs() {
+        if imprt.Path == path {
+            specs = append(specs, imprt)
+        }
+    }
+    return specs
+}
+
+// files with import "C", i.e. input for cgo
+func (p *Package) CgoFiles() []string {
+    files := make([]string, 0)
+    for _, imprt := range p.ImportSpecs() {
+        if imprt.Kind == Cgo {
+            files = append(files, imprt.Pos.Filename)
+        }
+    }
+    sort.Strings(files)
+    return files
+}
+
+func (i *Import) KindName() string {
+    switch i.Kind {
+    case Named:
+        return "named"
+    case Dot:
+        return "dot"
+    case Blank:
+        return "blank"
+    case Cgo:
+        return "cgo"
+    }
+    return "plain"
+}
+
+// file:line, "?" if we have no idea
+func (i *Import) Where() string {
+    if !i.Pos.IsValid() {
+        return "?"
+    }
+    return fmt.Sprintf("%s:%d", i.Pos.Filename, i.Pos.Line)
+}
+
+// as it would look in the source: import f "fmt"
+func (i *Import) String() string {
+    if i.Name != "" {
+        return fmt.Sprintf("import %s \"%s\"", i.Name, i.Path)
+    }
+    return fmt.Sprintf("import \"%s\"", i.Path)
+}
+
+type byPosition []*Import
+
+func (b byPosition) Len() int      { return len(b) }
+func (b byPosition) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byPosition) Less(i, j int) bool {
+    if b[i].Path != b[j].Path {
+        return b[i].Path < b[j].Path
+    }
+    if b[i].Pos.Filename != b[j].Pos.Filename {
+        return b[i].Pos.Filename < b[j].Pos.Filename
+    }
+    return b[i].Pos.Line < b[j].Pos.Line
+}
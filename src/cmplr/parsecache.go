@@ -0,0 +1,184 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "encoding/json"
+    "go/parser"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+    "utilz/global"
+    "utilz/handy"
+)
+
+// Parse needs the package name and import declarations of each file,
+// nothing more. Files are parsed by -jobs workers, and what they find
+// is kept on disk (one cache per source root) keyed by path, size and
+// modification time, i.e. files that did not change are not parsed.
+
+const parseCacheVersion = 1 // bump if parsedImports changes
+
+type parsedImports struct {
+    Size    int64
+    Mtime   int64 // nanoseconds
+    Package string
+    Imports []*Import
+}
+
+type parseCache struct {
+    Version int
+    Files   map[string]*parsedImports
+}
+
+// parsed[i] belongs to files[i], whatever order the workers finish in
+func parseAll(root string, files []string) []*parsedImports {
+
+    pathname := parseCachePath(root)
+    cached := loadParseCache(pathname)
+    parsed := make([]*parsedImports, len(files))
+
+    workers := global.GetInt("-jobs")
+    if workers < 1 {
+        workers = 1
+    }
+
+    jobs := make(chan int)
+    wg := new(sync.WaitGroup)
+
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            for i := range jobs {
+                parsed[i] = parseImports(files[i], cached[files[i]])
+            }
+            wg.Done()
+        }()
+    }
+
+    for i := 0; i < len(files); i++ {
+        jobs <- i
+    }
+
+    close(jobs)
+    wg.Wait()
+
+    // write back if anything changed, forget files that are gone;
+    // files we did not ask for (-test, -match) are kept
+    dirty := false
+
+    for i := 0; i < len(files); i++ {
+        if cached[files[i]] != parsed[i] {
+            cached[files[i]] = parsed[i]
+            dirty = true
+        }
+    }
+
+    for file := range cached {
+        if !handy.IsFile(file) {
+            delete(cached, file)
+            dirty = true
+        }
+    }
+
+    if dirty {
+        saveParseCache(pathname, cached)
+    }
+
+    return parsed
+}
+
+func parseImports(file string, cached *parsedImports) *parsedImports {
+
+    fi, e := os.Stat(file)
+
+    if e == nil && cached != nil &&
+        cached.Size == fi.Size() && cached.Mtime == fi.ModTime().UnixNano() {
+        return cached
+    }
+
+    tree, fset := getSyntaxTreeAndFileSetOrDie(file, parser.ImportsOnly)
+
+    p := &parsedImports{
+        Package: tree.Name.String(),
+        Imports: fileImports(tree, fset),
+    }
+
+    if e == nil {
+        p.Size = fi.Size()
+        p.Mtime = fi.ModTime().UnixNano()
+    }
+
+    return p
+}
+
+// $XDG_CACHE_HOME/godag/parse/<sha1 of root> or similar, files
+// (and positions) are named relative to root as given
+func parseCachePath(root string) string {
+
+    cache, e := os.UserCacheDir()
+
+    if e != nil {
+        cache = os.TempDir()
+    }
+
+    abs, e := filepath.Abs(root)
+
+    if e != nil {
+        abs = root
+    }
+
+    return filepath.Join(cache, "godag", "parse", handy.Sha1(abs+"\x00"+root)[:16]+".json")
+}
+
+// missing, broken or old cache => empty cache
+func loadParseCache(pathname string) map[string]*parsedImports {
+
+    pc := new(parseCache)
+    content, e := ioutil.ReadFile(pathname)
+
+    if e != nil || json.Unmarshal(content, pc) != nil ||
+        pc.Version != parseCacheVersion || pc.Files == nil {
+        return make(map[string]*parsedImports)
+    }
+
+    return pc.Files
+}
+
+// best effort, a cache we cannot write is just slower
+func saveParseCache(pathname string, files map[string]*parsedImports) {
+
+    content, e := json.Marshal(&parseCache{Version: parseCacheVersion, Files: files})
+
+    if e != nil || os.MkdirAll(filepath.Dir(pathname), 0755) != nil {
+        return
+    }
+
+    // several gd's may share a source tree, rename is atomic
+    tmp, e := ioutil.TempFile(filepath.Dir(pathname), ".parse")
+
+    if e != nil {
+        return
+    }
+
+    _, e = tmp.Write(content)
+    tmp.Close()
+
+    if e != nil || os.Rename(tmp.Name(), pathname) != nil {
+        os.Remove(tmp.Name())
+    }
+}
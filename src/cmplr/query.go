@@ -0,0 +1,344 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "encoding/json"
+    "fmt"
+    "parse/query"
+    "regexp"
+    "sort"
+    "strings"
+    "utilz/stringbuffer"
+)
+
+// --query: the graph is local packages and everything they import,
+// edges are import declarations (dependencies). results are ordered,
+// sorted by name unless somepath decides otherwise, see parse/query
+
+type queryGraph struct {
+    d         Dag
+    names     []string            // sorted
+    imports   map[string][]string // sorted
+    importers map[string][]string // sorted
+}
+
+// ordered set of package names
+type queryResult struct {
+    names  []string
+    member map[string]bool
+}
+
+func newQueryResult() *queryResult {
+    return &queryResult{make([]string, 0), make(map[string]bool)}
+}
+
+func (r *queryResult) add(name string) {
+    if !r.member[name] {
+        r.member[name] = true
+        r.names = append(r.names, name)
+    }
+}
+
+func (r *queryResult) sorted() *queryResult {
+    sort.Strings(r.names)
+    return r
+}
+
+func (d Dag) newQueryGraph() *queryGraph {
+
+    g := &queryGraph{
+        d:         d,
+        imports:   make(map[string][]string),
+        importers: make(map[string][]string),
+    }
+
+    all := newQueryResult()
+
+    for _, v := range d.sortedPackages() {
+        all.add(v.Name)
+        g.imports[v.Name] = v.Imports()
+        for _, dep := range g.imports[v.Name] {
+            all.add(dep)
+            g.importers[dep] = append(g.importers[dep], v.Name)
+        }
+    }
+
+    g.names = all.sorted().names
+
+    return g
+}
+
+// packages matching expr, see parse/query for the syntax
+func (d Dag) Query(expr query.Expr) ([]string, error) {
+
+    r, e := d.newQueryGraph().eval(expr)
+
+    if e != nil {
+        return nil, e
+    }
+
+    return r.names, nil
+}
+
+func (g *queryGraph) eval(expr query.Expr) (*queryResult, error) {
+
+    switch x := expr.(type) {
+    case *query.Word:
+        return g.word(x.Name)
+    case *query.Binary:
+        return g.binary(x)
+    case *query.Call:
+        return g.call(x)
+    }
+
+    return nil, fmt.Errorf("query: cannot evaluate: %s", expr)
+}
+
+// name or pattern: a/b/... => a/b and below, ... => everything
+func (g *queryGraph) word(name string) (*queryResult, error) {
+
+    r := newQueryResult()
+
+    if strings.HasSuffix(name, "...") {
+        prefix := strings.TrimSuffix(strings.TrimSuffix(name, "..."), "/")
+        for _, n := range g.names {
+            if prefix == "" || n == prefix || strings.HasPrefix(n, prefix+"/") {
+                r.add(n)
+            }
+        }
+        return r, nil
+    }
+
+    if _, ok := g.imports[name]; !ok && g.importers[name] == nil {
+        return nil, fmt.Errorf("query: no such package: '%s'", name)
+    }
+
+    r.add(name)
+
+    return r, nil
+}
+
+func (g *queryGraph) binary(b *query.Binary) (*queryResult, error) {
+
+    left, e := g.eval(b.Left)
+
+    if e != nil {
+        return nil, e
+    }
+
+    right, e := g.eval(b.Right)
+
+    if e != nil {
+        return nil, e
+    }
+
+    r := newQueryResult()
+
+    switch b.Op {
+    case query.Union:
+        for _, n := range append(left.names, right.names...) {
+            r.add(n)
+        }
+    case query.Intersect, query.Except:
+        keep := b.Op == query.Intersect
+        for _, n := range left.names {
+            if right.member[n] == keep {
+                r.add(n)
+            }
+        }
+    }
+
+    return r.sorted(), nil
+}
+
+func (g *queryGraph) call(c *query.Call) (*queryResult, error) {
+
+    args := make([]*queryResult, len(c.Args))
+
+    for i := 0; i < len(c.Args); i++ {
+        // literals: depth of deps/rdeps, regex of filter
+        if (i == 1 && (c.Func == "deps" || c.Func == "rdeps")) ||
+            (i == 0 && c.Func == "filter") {
+            continue
+        }
+        arg, e := g.eval(c.Args[i])
+        if e != nil {
+            return nil, e
+        }
+        args[i] = arg
+    }
+
+    switch c.Func {
+    case "deps":
+        return g.reach(args[0], g.imports, c.Depth()), nil
+    case "rdeps":
+        return g.reach(args[0], g.importers, c.Depth()), nil
+    case "allpaths":
+        from := g.reach(args[0], g.imports, -1)
+        to := g.reach(args[1], g.importers, -1)
+        r := newQueryResult()
+        for _, n := range from.names {
+            if to.member[n] {
+                r.add(n)
+            }
+        }
+        return r, nil
+    case "somepath":
+        return g.somepath(args[0], args[1]), nil
+    case "filter":
+        re := regexp.MustCompile(c.Args[0].String()) // checked by Parse
+        r := newQueryResult()
+        for _, n := range args[1].names {
+            if re.MatchString(n) {
+                r.add(n)
+            }
+        }
+        return r, nil
+    case "local":
+        r := newQueryResult()
+        for _, n := range args[0].names {
+            if g.d.localDependency(n) {
+                r.add(n)
+            }
+        }
+        return r, nil
+    }
+
+    return nil, fmt.Errorf("query: unknown function: %s", c.Func)
+}
+
+// start and what can be reached following edges, depth < 0 => no limit
+func (g *queryGraph) reach(start *queryResult, edges map[string][]string, depth int) *queryResult {
+
+    r := newQueryResult()
+    level := start.names
+
+    for _, n := range level {
+        r.add(n)
+    }
+
+    for ; len(level) > 0 && depth != 0; depth-- {
+        next := make([]string, 0)
+        for _, n := range level {
+            for _, m := range edges[n] {
+                if !r.member[m] {
+                    r.add(m)
+                    next = append(next, m)
+                }
+            }
+        }
+        level = next
+    }
+
+    return r.sorted()
+}
+
+// shortest path from one of from to one of to, in path order
+func (g *queryGraph) somepath(from, to *queryResult) *queryResult {
+
+    r := newQueryResult()
+    parent := make(map[string]string)
+    level := make([]string, 0)
+
+    for _, n := range from.sorted().names {
+        parent[n] = ""
+        level = append(level, n)
+    }
+
+    for len(level) > 0 {
+        next := make([]string, 0)
+        for _, n := range level {
+            if to.member[n] {
+                path := []string{n}
+                for p := parent[n]; p != ""; p = parent[p] {
+                    path = append([]string{p}, path...)
+                }
+                for _, p := range path {
+                    r.add(p)
+                }
+                return r
+            }
+            for _, m := range g.imports[n] {
+                if _, seen := parent[m]; !seen {
+                    parent[m] = n
+                    next = append(next, m)
+                }
+            }
+        }
+        level = next
+    }
+
+    return r
+}
+
+// one name per line, graphviz dot, or JSON
+func (d Dag) PrintQuery(names []string, format string) {
+
+    g := d.newQueryGraph()
+    member := make(map[string]bool)
+
+    for _, n := range names {
+        member[n] = true
+    }
+
+    // imports within the result
+    edges := func(name string) []string {
+        deps := make([]string, 0)
+        for _, dep := range g.imports[name] {
+            if member[dep] {
+                deps = append(deps, dep)
+            }
+        }
+        return deps
+    }
+
+    switch format {
+    case "dot":
+        sb := stringbuffer.NewSize(500)
+        sb.Add("digraph query {\n\trankdir=LR;\n")
+        for _, n := range names {
+            sb.Add(fmt.Sprintf("\t\"%s\";\n", n))
+        }
+        for _, n := range names {
+            for _, dep := range edges(n) {
+                attr := ""
+                if d.localDependency(n) {
+                    attr = d[n].dotAttributes(dep)
+                }
+                sb.Add(fmt.Sprintf("\t\"%s\" -> \"%s\"%s;\n", n, dep, attr))
+            }
+        }
+        sb.Add("}\n")
+        fmt.Print(sb.String())
+    case "json":
+        type node struct {
+            Name    string   `json:"name"`
+            Local   bool     `json:"local"`
+            Imports []string `json:"imports"`
+        }
+        nodes := make([]*node, 0, len(names))
+        for _, n := range names {
+            nodes = append(nodes, &node{n, d.localDependency(n), edges(n)})
+        }
+        content, _ := json.MarshalIndent(nodes, "", "  ")
+        fmt.Printf("%s\n", content)
+    default:
+        for _, n := range names {
+            fmt.Printf("%s\n", n)
+        }
+    }
+}
@@ -0,0 +1,93 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "parse/query"
+    "strings"
+    "testing"
+)
+
+// start/main -> cmplr/dag -> utilz/handy -> os
+//            -> utilz/say -> fmt
+//            -> utilz/handy
+func queryDag() Dag {
+
+    d := New()
+
+    deps := map[string][]string{
+        "start/main":  {"cmplr/dag", "utilz/say", "utilz/handy"},
+        "cmplr/dag":   {"utilz/handy"},
+        "utilz/handy": {"os"},
+        "utilz/say":   {"fmt"},
+    }
+
+    for name, imports := range deps {
+        p := newPackage()
+        p.Name = name
+        for _, imprt := range imports {
+            p.dependencies.Add(imprt)
+        }
+        d[name] = p
+    }
+
+    return d
+}
+
+func TestQuery(t *testing.T) {
+
+    d := queryDag()
+
+    // expression => result, in order
+    results := map[string]string{
+        "utilz/...":                          "utilz/handy utilz/say",
+        "deps(cmplr/dag)":                    "cmplr/dag os utilz/handy",
+        "deps(start/main, 1)":                "cmplr/dag start/main utilz/handy utilz/say",
+        "deps(start/main, 0)":                "start/main",
+        "rdeps(utilz/handy)":                 "cmplr/dag start/main utilz/handy",
+        "rdeps(os, 1)":                       "os utilz/handy",
+        "allpaths(start/main, utilz/handy)":  "cmplr/dag start/main utilz/handy",
+        "allpaths(utilz/say, os)":            "",
+        "somepath(start/main, os)":           "start/main utilz/handy os",
+        "somepath(utilz/say, os)":            "",
+        "local(deps(start/main))":            "cmplr/dag start/main utilz/handy utilz/say",
+        "deps(start/main) - local(...)":      "fmt os",
+        "utilz/say + cmplr/dag":              "cmplr/dag utilz/say",
+        "deps(cmplr/dag) ^ deps(utilz/say)":  "",
+        "rdeps(os) ^ deps(cmplr/dag)":        "cmplr/dag os utilz/handy",
+        "filter('^utilz/', deps(start/main))": "utilz/handy utilz/say",
+    }
+
+    for src, want := range results {
+        expr, e := query.Parse(src)
+        if e != nil {
+            t.Fatalf("query.Parse(%s): %s\n", src, e)
+        }
+        names, e := d.Query(expr)
+        if e != nil {
+            t.Fatalf("d.Query(%s): %s\n", src, e)
+        }
+        if got := strings.Join(names, " "); got != want {
+            t.Fatalf("d.Query(%s): '%s' != '%s'\n", src, got, want)
+        }
+    }
+
+    expr, _ := query.Parse("deps(nosuch)")
+
+    if _, e := d.Query(expr); e == nil {
+        t.Fatalf("d.Query(deps(nosuch)) should fail\n")
+    }
+}
@@ -0,0 +1,493 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strings"
+    "time"
+    "utilz/event"
+    "utilz/global"
+    "utilz/handy"
+    "utilz/say"
+    "utilz/stringbuffer"
+)
+
+// --test-report: test binaries run under 'go tool test2json', the
+// events are sorted into one suite per package and written as JUnit
+// XML or TAP once all tests have run.
+//
+//  junit=path.xml   JUnit XML written to path.xml
+//  tap[=path]       TAP version 13 written to path, or stdout (the
+//                   progress messages are muted, TAP is all we print)
+
+type testEvent struct {
+    Action  string
+    Test    string
+    Elapsed float64 // seconds
+    Output  string
+}
+
+type testCase struct {
+    name    string
+    result  string // pass, fail, skip
+    elapsed float64
+    output  *stringbuffer.StringBuffer
+}
+
+type testSuite struct {
+    name    string
+    elapsed float64
+    cases   []*testCase
+}
+
+var suites []*testSuite
+
+func Reporting() bool {
+    return global.GetString("-test-report") != ""
+}
+
+// die early if --test-report makes no sense
+func CheckTestReport() {
+    if Reporting() {
+        if format, path := reportTarget(); format == "tap" && path == "" {
+            say.Mute()
+        }
+    }
+}
+
+func reportTarget() (format, path string) {
+
+    format = global.GetString("-test-report")
+
+    if i := strings.Index(format, "="); i >= 0 {
+        format, path = format[:i], format[i+1:]
+    }
+
+    switch format {
+    case "tap":
+    case "junit":
+        if path == "" {
+            log.Fatalf("[ERROR] --test-report: junit needs a file: junit=path.xml\n")
+        }
+    default:
+        log.Fatalf("[ERROR] --test-report: '%s' unknown format (junit=path.xml, tap)\n",
+            global.GetString("-test-report"))
+    }
+
+    return format, path
+}
+
+// the test binary reports to test2json, which reports to us
+func reportArgv(argv []string) []string {
+
+    wrapped := []string{findGo(), "tool", "test2json"}
+
+    for i := 0; i < len(argv); i++ {
+        if argv[i] != "-test.v" {
+            wrapped = append(wrapped, argv[i])
+        }
+    }
+
+    return append(wrapped, "-test.v=test2json")
+}
+
+// single test binary: run it, and print what it would have printed
+func RunTestReport(argv []string, names map[string]string) bool {
+
+    start := time.Now()
+    stdout, stderr, status, e := handy.Capture(reportArgv(argv))
+    elapsed := time.Since(start)
+
+    events := testEvents(stdout)
+    display := testDisplay(events)
+
+    collected := collectSuites(events, func(prefix string) string {
+        if name, ok := names[prefix]; ok {
+            return name
+        }
+        return prefix
+    })
+
+    if e != nil && failures(collected) == 0 {
+        collected = append(collected, &testSuite{
+            name:    global.GetString("-test-bin"),
+            elapsed: elapsed.Seconds(),
+            cases:   []*testCase{exitCase(status, e, stderr)},
+        })
+    }
+
+    suites = append(suites, collected...)
+
+    if event.Enabled() {
+        result := "ok"
+        if e != nil {
+            result = "fail"
+        }
+        event.Emit(&event.Event{Action: "test",
+            Output: global.GetString("-test-bin"), Argv: argv,
+            Elapsed: elapsed.Seconds(), Exit: &status, Result: result,
+            Stdout: display, Stderr: stderr})
+    } else if e == nil {
+        say.Printf("%s%s", display, stderr)
+    } else {
+        fmt.Fprintf(os.Stderr, "%s%s", display, stderr)
+    }
+
+    WriteTestReport()
+
+    return e == nil
+}
+
+// -per-package: what runTest captured becomes a suite named pkgname
+func reportedTest(pkgname string, r *testResult, status int, e error) {
+
+    events := testEvents(r.stdout)
+    r.stdout = testDisplay(events)
+
+    collected := collectSuites(events, func(string) string {
+        return pkgname
+    })
+
+    if len(collected) == 0 {
+        collected = append(collected, &testSuite{name: pkgname})
+    }
+
+    collected[0].elapsed = r.elapsed.Seconds()
+
+    if e != nil && failures(collected) == 0 {
+        collected[0].cases = append(collected[0].cases, exitCase(status, e, r.stderr))
+    }
+
+    r.suite = collected[0]
+}
+
+// the binary failed, but no test did (os.Exit, TestMain, init..)
+func exitCase(status int, e error, stderr string) *testCase {
+
+    c := &testCase{
+        name:   fmt.Sprintf("[exit status %d]", status),
+        result: "fail",
+        output: stringbuffer.New(),
+    }
+
+    c.output.Add(stderr)
+    if status == -1 {
+        c.output.Add(e.Error())
+    }
+
+    return c
+}
+
+func testEvents(stdout string) []*testEvent {
+
+    events := make([]*testEvent, 0)
+
+    for _, line := range strings.Split(stdout, "\n") {
+        if strings.HasPrefix(line, "{") {
+            ev := new(testEvent)
+            if json.Unmarshal([]byte(line), ev) == nil {
+                events = append(events, ev)
+            }
+        }
+    }
+
+    return events
+}
+
+// all of it if -verbose, otherwise package output + failed tests
+func testDisplay(events []*testEvent) string {
+
+    verbose := global.GetBool("-verbose") || global.GetBool("-test.v")
+    failed := make(map[string]bool)
+
+    for _, ev := range events {
+        if ev.Action == "fail" && ev.Test != "" {
+            failed[ev.Test] = true
+        }
+    }
+
+    sb := stringbuffer.New()
+
+    for _, ev := range events {
+        if ev.Action != "output" {
+            continue
+        }
+        if verbose || ev.Test == "" ||
+            (failed[ev.Test] && !strings.HasPrefix(ev.Output, "=== ")) {
+            sb.Add(ev.Output)
+        }
+    }
+
+    return sb.String()
+}
+
+// suiteOf gives the package of a test from its prefix, suites are
+// listed in the order their first test ran
+func collectSuites(events []*testEvent, suiteOf func(prefix string) string) []*testSuite {
+
+    collected := make([]*testSuite, 0)
+    bySuite := make(map[string]*testSuite)
+    byTest := make(map[string]*testCase)
+
+    for _, ev := range events {
+
+        if ev.Test == "" {
+            continue
+        }
+
+        c, ok := byTest[ev.Test]
+
+        if !ok {
+            prefix, name := splitTestName(ev.Test)
+            sname := suiteOf(prefix)
+            s, ok := bySuite[sname]
+            if !ok {
+                s = &testSuite{name: sname}
+                bySuite[sname] = s
+                collected = append(collected, s)
+            }
+            c = &testCase{name: name, output: stringbuffer.New()}
+            byTest[ev.Test] = c
+            s.cases = append(s.cases, c)
+        }
+
+        switch ev.Action {
+        case "output":
+            if !isFrame(ev.Output) {
+                c.output.Add(ev.Output)
+            }
+        case "pass", "fail", "skip":
+            c.result = ev.Action
+            c.elapsed = ev.Elapsed
+        }
+    }
+
+    // one test binary: time spent by the top level tests
+    for _, s := range collected {
+        for _, c := range s.cases {
+            if !strings.Contains(c.name, "/") {
+                s.elapsed += c.elapsed
+            }
+        }
+    }
+
+    return collected
+}
+
+// 'sname.TestFoo/sub' -> sname, TestFoo/sub; fuzz targets are only
+// qualified by the harness if several packages have one by that name,
+// otherwise their prefix is the name of the target
+func splitTestName(test string) (prefix, name string) {
+
+    top := test
+
+    if i := strings.Index(top, "/"); i >= 0 {
+        top = top[:i]
+    }
+
+    if i := strings.Index(top, "."); i >= 0 {
+        return test[:i], test[i+1:]
+    }
+
+    return top, test
+}
+
+// === RUN, --- PASS: and friends, the result is reported anyway
+func isFrame(line string) bool {
+
+    line = strings.TrimLeft(line, " ")
+
+    for _, prefix := range []string{"=== ", "--- PASS: ", "--- FAIL: ", "--- SKIP: "} {
+        if strings.HasPrefix(line, prefix) {
+            return true
+        }
+    }
+
+    return false
+}
+
+func failures(collected []*testSuite) (n int) {
+    for _, s := range collected {
+        for _, c := range s.cases {
+            if c.result == "fail" {
+                n++
+            }
+        }
+    }
+    return n
+}
+
+// a test that never finished (panic, timeout) failed
+func (c *testCase) status() string {
+    if c.result == "" {
+        return "fail"
+    }
+    return c.result
+}
+
+func WriteTestReport() {
+
+    if !Reporting() {
+        return
+    }
+
+    format, path := reportTarget()
+
+    out := io.Writer(os.Stdout)
+
+    if path != "" {
+        fh, e := os.Create(path)
+        if e != nil {
+            log.Fatalf("[ERROR] %s\n", e)
+        }
+        defer fh.Close()
+        out = fh
+    }
+
+    switch format {
+    case "junit":
+        writeJUnit(out)
+    case "tap":
+        writeTAP(out)
+    }
+
+    suites = nil // --watch: next run, next report
+}
+
+type junitTestSuites struct {
+    XMLName  xml.Name         `xml:"testsuites"`
+    Tests    int              `xml:"tests,attr"`
+    Failures int              `xml:"failures,attr"`
+    Skipped  int              `xml:"skipped,attr"`
+    Time     string           `xml:"time,attr"`
+    Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+    Name     string          `xml:"name,attr"`
+    Tests    int             `xml:"tests,attr"`
+    Failures int             `xml:"failures,attr"`
+    Skipped  int             `xml:"skipped,attr"`
+    Time     string          `xml:"time,attr"`
+    Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+    Classname string        `xml:"classname,attr"`
+    Name      string        `xml:"name,attr"`
+    Time      string        `xml:"time,attr"`
+    Failure   *junitMessage `xml:"failure,omitempty"`
+    Skipped   *junitMessage `xml:"skipped,omitempty"`
+    SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+    Message string `xml:"message,attr"`
+    Text    string `xml:",chardata"`
+}
+
+func writeJUnit(out io.Writer) {
+
+    var elapsed float64
+
+    all := junitTestSuites{}
+
+    for _, s := range suites {
+
+        js := junitTestSuite{Name: s.name, Time: seconds(s.elapsed)}
+
+        for _, c := range s.cases {
+            jc := junitTestCase{Classname: s.name, Name: c.name, Time: seconds(c.elapsed)}
+            switch c.status() {
+            case "fail":
+                jc.Failure = &junitMessage{Message: "Failed", Text: c.output.String()}
+                js.Failures++
+            case "skip":
+                jc.Skipped = &junitMessage{Message: strings.TrimSpace(c.output.String())}
+                js.Skipped++
+            default:
+                jc.SystemOut = c.output.String()
+            }
+            js.Cases = append(js.Cases, jc)
+        }
+
+        js.Tests = len(js.Cases)
+        all.Tests += js.Tests
+        all.Failures += js.Failures
+        all.Skipped += js.Skipped
+        elapsed += s.elapsed
+        all.Suites = append(all.Suites, js)
+    }
+
+    all.Time = seconds(elapsed)
+
+    b, e := xml.MarshalIndent(&all, "", "  ")
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    fmt.Fprintf(out, "%s%s\n", xml.Header, b)
+}
+
+func writeTAP(out io.Writer) {
+
+    n := 0
+
+    for _, s := range suites {
+        n += len(s.cases)
+    }
+
+    fmt.Fprintf(out, "TAP version 13\n1..%d\n", n)
+
+    n = 0
+
+    for _, s := range suites {
+        for _, c := range s.cases {
+            n++
+            switch c.status() {
+            case "fail":
+                fmt.Fprintf(out, "not ok %d - %s %s\n", n, s.name, c.name)
+                fmt.Fprintf(out, "  ---\n  duration_ms: %.0f\n", c.elapsed*1000)
+                if msg := strings.TrimRight(c.output.String(), "\n"); msg != "" {
+                    fmt.Fprintf(out, "  message: |\n")
+                    for _, line := range strings.Split(msg, "\n") {
+                        fmt.Fprintf(out, "    %s\n", line)
+                    }
+                }
+                fmt.Fprintf(out, "  ...\n")
+            case "skip":
+                reason := strings.TrimSpace(c.output.String())
+                if i := strings.Index(reason, "\n"); i >= 0 {
+                    reason = reason[:i]
+                }
+                fmt.Fprintf(out, "ok %d - %s %s # SKIP %s\n", n, s.name, c.name, reason)
+            default:
+                fmt.Fprintf(out, "ok %d - %s %s\n", n, s.name, c.name)
+            }
+        }
+    }
+}
+
+func seconds(s float64) string {
+    return fmt.Sprintf("%.3f", s)
+}
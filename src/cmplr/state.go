@@ -0,0 +1,221 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "utilz/handy"
+    "utilz/stringbuffer"
+)
+
+// Build state: modification times are not to be trusted, a checkout
+// or a skewed clock on some network disk will make them lie to us.
+// Instead we remember a fingerprint (sha1) of everything that went
+// into each object file the last time it was compiled: the compiler
+// argv, the content of the source files and the objects of all local
+// dependencies. If the fingerprint is unchanged, so is the object.
+//
+// The state file is a plain text file, one package per line:
+//
+//  package-name fingerprint
+
+var stateLocker = new(sync.Mutex)
+var stateFile string // "" => no build state, fall back to mtime
+var state = make(map[string]string)
+
+// read build state from pathname, a missing file is an empty state
+func LoadState(pathname string) {
+
+    stateLocker.Lock()
+    defer stateLocker.Unlock()
+
+    stateFile = pathname
+    state = make(map[string]string)
+
+    if !handy.IsFile(pathname) {
+        return
+    }
+
+    content, e := ioutil.ReadFile(pathname)
+
+    if e != nil {
+        log.Printf("[WARNING] failed to read build state: %s\n", e)
+        return
+    }
+
+    lines := strings.Split(string(content), "\n")
+
+    for i := 0; i < len(lines); i++ {
+        fields := strings.Fields(lines[i])
+        if len(fields) == 2 {
+            state[fields[0]] = fields[1]
+        }
+    }
+}
+
+// write build state back to the file it was loaded from, a state file
+// is complete or not written (rename), whatever happens while writing
+func SaveState() {
+
+    stateLocker.Lock()
+    defer stateLocker.Unlock()
+
+    if stateFile == "" {
+        return
+    }
+
+    names := make([]string, 0, len(state))
+    for k, _ := range state {
+        names = append(names, k)
+    }
+    sort.Strings(names)
+
+    sb := stringbuffer.New()
+    for i := 0; i < len(names); i++ {
+        sb.Add(names[i] + " " + state[names[i]] + "\n")
+    }
+
+    e := writeState(sb.Bytes())
+
+    if e != nil {
+        log.Printf("[WARNING] failed to write build state: %s\n", e)
+    }
+}
+
+func writeState(content []byte) error {
+
+    tmp, e := ioutil.TempFile(filepath.Dir(stateFile), filepath.Base(stateFile))
+
+    if e != nil {
+        return e
+    }
+
+    _, e = tmp.Write(content)
+    tmp.Close()
+
+    if e == nil {
+        e = os.Chmod(tmp.Name(), 0644)
+    }
+
+    if e == nil {
+        e = os.Rename(tmp.Name(), stateFile)
+    }
+
+    if e != nil {
+        os.Remove(tmp.Name())
+    }
+
+    return e
+}
+
+// temporary packages (testing) should not linger in the build state
+func ForgetState(name string) {
+    stateLocker.Lock()
+    delete(state, name)
+    stateLocker.Unlock()
+}
+
+func hasState() bool {
+    stateLocker.Lock()
+    defer stateLocker.Unlock()
+    return stateFile != ""
+}
+
+func getState(name string) (fprint string, ok bool) {
+    stateLocker.Lock()
+    fprint, ok = state[name]
+    stateLocker.Unlock()
+    return fprint, ok
+}
+
+func setState(name, fprint string) {
+    stateLocker.Lock()
+    state[name] = fprint
+    stateLocker.Unlock()
+}
+
+// sha1 of compiler argv (+ cgo steps) + source files + other inputs (importcfg)
+// + objects of local dependencies, the dependencies are compiled
+// before we get here (waiter)
+func (p *Package) fingerprint() (string, error) {
+
+    sb := stringbuffer.New()
+
+    cmds := p.Commands()
+    for i := 0; i < len(cmds); i++ {
+        sb.Add(strings.Join(cmds[i], "\x00"))
+        sb.Add("\n")
+    }
+
+    inputs := append(append([]string{}, p.Files...), p.Inputs...)
+
+    for i := 0; i < len(inputs); i++ {
+        h, e := handy.Sha1File(inputs[i])
+        if e != nil {
+            return "", e
+        }
+        sb.Add(inputs[i] + " " + h + "\n")
+    }
+
+    locals := make([]*Package, len(p.locals))
+    copy(locals, p.locals)
+    sort.Sort(byName(locals))
+
+    for i := 0; i < len(locals); i++ {
+        h, e := handy.Sha1File(locals[i].Output)
+        if e != nil {
+            return "", e
+        }
+        sb.Add(locals[i].Name + " " + h + "\n")
+    }
+
+    return handy.Sha1(sb.String()), nil
+}
+
+// remember fingerprint of a package that compiled without trouble
+func (p *Package) remember() {
+
+    var e error
+
+    if !hasState() {
+        return
+    }
+
+    if p.fprint == "" {
+        p.fprint, e = p.fingerprint()
+        if e != nil {
+            log.Printf("[WARNING] %s\n", e)
+            return
+        }
+    }
+
+    setState(p.Name, p.fprint)
+
+    // a fatal error later on should not cost us what is compiled
+    SaveState()
+}
+
+type byName []*Package
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,595 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dag
+
+import (
+    "fmt"
+    "go/ast"
+    "go/parser"
+    "log"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "utilz/global"
+    "utilz/handy"
+    "utilz/stringbuffer"
+    "utilz/stringset"
+)
+
+// Test harness: a generated main package (_main.go) that imports the
+// packages holding tests and hands their Test/Benchmark functions to
+// testing.Main. MakeMainTest creates one harness for all packages,
+// MakeMainTests one harness per package (-per-package).
+
+func (d Dag) MakeMainTest(root, libroot string) ([]*Package, string, string) {
+
+    src, imports := testMainSource(d.testedPackages(), d.coveredPackages())
+
+    tmpstub, tmpdir, tmplib := makeTestDir(root, libroot)
+
+    tmpfile := filepath.Join(tmpdir, "_main.go")
+    writeTestMain(tmpfile, src)
+
+    p := testMainPackage(filepath.Join(tmpstub, "main"), tmpfile, imports)
+
+    return []*Package{p}, tmpdir, tmplib
+}
+
+// one harness per package with tests, mains[i] tests tested[i]; the
+// tests of package x_test go into the same binary as those of x
+func (d Dag) MakeMainTests(root, libroot string) (mains, tested []*Package, tmpdir, tmplib string) {
+
+    var tmpstub string
+
+    tmpstub, tmpdir, tmplib = makeTestDir(root, libroot)
+    tested, groups := testGroups(d.testedPackages())
+
+    for i := 0; i < len(tested); i++ {
+
+        sub := testDirName(tested[i].Name)
+        handy.DirOrMkdir(filepath.Join(tmpdir, sub))
+
+        // a binary covers its own package, unless told otherwise
+        covered := d.coveredPackages()
+        if global.GetString("-coverpkg") == "" {
+            covered = nil
+            if tested[i].CoverVars != nil {
+                covered = []*Package{tested[i]}
+            }
+        }
+
+        src, imports := testMainSource(groups[i], covered)
+
+        tmpfile := filepath.Join(tmpdir, sub, "_main.go")
+        writeTestMain(tmpfile, src)
+
+        mains = append(mains, testMainPackage(
+            filepath.Join(tmpstub, sub, "main"), tmpfile, imports))
+    }
+
+    return mains, tested, tmpdir, tmplib
+}
+
+// packages with tests, benchmarks or examples, sorted by name
+func (d Dag) testedPackages() []*Package {
+
+    tested := make([]*Package, 0)
+
+    for _, v := range d.sortedPackages() {
+        // not our tests
+        if v.Foreign() {
+            continue
+        }
+        // not affected by --since/--changed
+        if skipTests(v) {
+            continue
+        }
+        if v.testCollector().FoundAnything() {
+            tested = append(tested, v)
+        }
+    }
+
+    return tested
+}
+
+// packages to instrument for --cover: those matching --coverpkg, or
+// the packages under test; never main, tests or foreign packages
+func (d Dag) CoverPackages() []*Package {
+
+    var match *regexp.Regexp
+    var e error
+
+    tested := make(map[*Package]bool)
+
+    if global.GetString("-coverpkg") != "" {
+        match, e = regexp.Compile(global.GetString("-coverpkg"))
+        if e != nil {
+            log.Fatalf("[ERROR] --coverpkg: %s\n", e)
+        }
+    } else {
+        keys, _ := testGroups(d.testedPackages())
+        for i := 0; i < len(keys); i++ {
+            tested[keys[i]] = true
+        }
+    }
+
+    pkgs := make([]*Package, 0)
+
+    for _, v := range d.sortedPackages() {
+        if v.Foreign() || v.ShortName == "main" || v.IsExternalTest() {
+            continue
+        }
+        if (match != nil && match.MatchString(v.Name)) || tested[v] {
+            pkgs = append(pkgs, v)
+        }
+    }
+
+    return pkgs
+}
+
+// instrumented packages, sorted by name
+func (d Dag) coveredPackages() []*Package {
+    pkgs := make([]*Package, 0)
+    for _, v := range d.sortedPackages() {
+        if v.CoverVars != nil {
+            pkgs = append(pkgs, v)
+        }
+    }
+    return pkgs
+}
+
+// x and x_test (if any) share a harness, named after x
+func testGroups(pkgs []*Package) (tested []*Package, groups [][]*Package) {
+
+    index := make(map[*Package]int)
+
+    for _, v := range pkgs {
+        key := v
+        if v.testOf != nil {
+            key = v.testOf
+        }
+        i, ok := index[key]
+        if !ok {
+            i = len(tested)
+            index[key] = i
+            tested = append(tested, key)
+            groups = append(groups, nil)
+        }
+        groups[i] = append(groups[i], v)
+    }
+
+    return tested, groups
+}
+
+// --test-report: package names of tests in a single test binary, keyed
+// by the prefix in 'sname.TestFoo' or the name of a fuzz target; a
+// short name shared by several packages is ambiguous, it maps to itself
+func (d Dag) TestSuites() map[string]string {
+
+    suites := make(map[string]string)
+    shared := stringset.New()
+
+    tested, groups := testGroups(d.testedPackages())
+
+    for i := 0; i < len(tested); i++ {
+        for _, v := range groups[i] {
+            if name, ok := suites[v.ShortName]; ok && name != tested[i].Name {
+                shared.Add(v.ShortName)
+            }
+            suites[v.ShortName] = tested[i].Name
+            for _, fn := range v.testCollector().FuzzFuncs {
+                suites[fn] = tested[i].Name
+            }
+        }
+    }
+
+    for _, sname := range shared.Slice() {
+        suites[sname] = sname
+    }
+
+    return suites
+}
+
+func (v *Package) testCollector() *TestCollector {
+
+    collector := newTestCollector()
+
+    for i := 0; i < len(v.Files); i++ {
+        if v.IsExternalTest() || strings.HasSuffix(v.Files[i], "_test.go") {
+            tree := getSyntaxTreeOrDie(v.Files[i], parser.ParseComments)
+            collector.comments = tree.Comments
+            ast.Walk(collector, tree)
+        }
+    }
+
+    return collector
+}
+
+// source of the harness + the packages it imports
+func testMainSource(pkgs, covered []*Package) (string, *stringset.StringSet) {
+
+    var lname, sname string
+    var testMain, testMainPkg string // lname.TestMain, package
+
+    sbImports := stringbuffer.NewSize(300)
+    imprtSet := stringset.New()
+    imprtPaths := stringset.New() // dependencies of the test main package
+    sbTests := stringbuffer.NewSize(1000)
+    sbBench := stringbuffer.NewSize(1000)
+    sbExample := stringbuffer.NewSize(1000)
+    sbFuzz := stringbuffer.NewSize(1000)
+    sbCover := stringbuffer.NewSize(1000)
+    nfuzz := 0
+
+    sbImports.Add("\n// autogenerated code\n\n")
+    sbImports.Add("package main\n\n")
+    imprtSet.Add("import \"regexp\"\n")
+    imprtSet.Add("import \"testing\"\n")
+    imprtPaths.Add("regexp")
+    imprtPaths.Add("testing")
+
+    sbTests.Add("\n\nvar tests = []testing.InternalTest{\n")
+    sbBench.Add("\n\nvar benchmarks = []testing.InternalBenchmark{\n")
+    sbExample.Add("\n\nvar examples = []testing.InternalExample{\n")
+    sbFuzz.Add("\n\nvar fuzzTargets = []testing.InternalFuzzTarget{\n")
+
+    collectors := make(map[*Package]*TestCollector)
+    fuzzNames := make(map[string]int) // FuzzX => packages defining it
+
+    for _, v := range pkgs {
+        collectors[v] = v.testCollector()
+        for _, fn := range collectors[v].FuzzFuncs {
+            fuzzNames[fn]++
+        }
+    }
+
+    for _, v := range pkgs {
+
+        sname = v.ShortName
+        lname = v.ShortName
+
+        collector := collectors[v]
+
+        imprtPaths.Add(v.Name)
+
+        // only compiled, nothing to refer to
+        if len(collector.TestFuncs)+len(collector.BenchFuncs)+
+            len(collector.Examples)+len(collector.FuzzFuncs) == 0 &&
+            !collector.TestMain {
+            imprtSet.Add(fmt.Sprintf("import _ \"%s\"\n", v.Name))
+            continue
+        }
+
+        if importAlias(v.Name) != v.Name {
+            lname = importAlias(v.Name)
+            imprtSet.Add(fmt.Sprintf("import %s \"%s\"\n", lname, v.Name))
+        } else {
+            imprtSet.Add(fmt.Sprintf("import \"%s\"\n", v.Name))
+        }
+
+        // add tests
+        for i := 0; i < len(collector.TestFuncs); i++ {
+            fn := collector.TestFuncs[i]
+            sbTests.Add(fmt.Sprintf(
+                "testing.InternalTest{\"%s.%s\", %s.%s },\n",
+                sname, fn, lname, fn))
+        }
+
+        // add benchmarks
+        for i := 0; i < len(collector.BenchFuncs); i++ {
+            fn := collector.BenchFuncs[i]
+            sbBench.Add(fmt.Sprintf(
+                "testing.InternalBenchmark{\"%s.%s\", %s.%s },\n",
+                sname, fn, lname, fn))
+        }
+
+        // add examples, those without output are only compiled
+        for i := 0; i < len(collector.Examples); i++ {
+            ex := collector.Examples[i]
+            sbExample.Add(fmt.Sprintf(
+                "testing.InternalExample{Name: \"%s.%s\", F: %s.%s, Output: %s, Unordered: %t},\n",
+                sname, ex.Name, lname, ex.Name, strconv.Quote(ex.Output), ex.Unordered))
+        }
+
+        // add fuzz targets, named like 'go test' names them, since
+        // the seed corpus is testdata/fuzz/<name> in the package dir;
+        // names found in several packages are qualified like tests
+        for i := 0; i < len(collector.FuzzFuncs); i++ {
+            fn := collector.FuzzFuncs[i]
+            name := fn
+            dir, _ := filepath.Abs(v.SrcDir())
+            if fuzzNames[fn] > 1 {
+                name = sname + "." + fn
+                if handy.IsDir(filepath.Join(dir, "testdata", "fuzz", fn)) {
+                    log.Printf("[WARNING] %s: seed corpus of %s not used, "+
+                        "%s is in several packages, try --per-package\n", v.Name, fn, fn)
+                }
+            }
+            sbFuzz.Add(fmt.Sprintf(
+                "testing.InternalFuzzTarget{Name: \"%s\", Fn: inDir(%s, %s.%s)},\n",
+                name, strconv.Quote(dir), lname, fn))
+            nfuzz++
+        }
+
+        // one test binary, one TestMain
+        if collector.TestMain {
+            if testMain != "" {
+                log.Fatalf("[ERROR] TestMain in %s and %s, try --per-package\n",
+                    testMainPkg, v.Name)
+            }
+            testMain = lname + ".TestMain"
+            testMainPkg = v.Name
+        }
+    }
+
+    // counters of instrumented packages are registered at init
+    if len(covered) > 0 {
+        sbCover.Add("\nfunc init(){\n")
+    }
+
+    for i, v := range covered {
+        alias := fmt.Sprintf("_cover%d", i)
+        imprtSet.Add(fmt.Sprintf("import %s \"%s\"\n", alias, v.Name))
+        imprtPaths.Add(v.Name)
+        for _, cv := range v.CoverVars {
+            c := alias + "." + cv.Var
+            sbCover.Add(fmt.Sprintf(
+                "coverRegister(\"%s\", \"%s\", %s.Count[:], %s.Pos[:], %s.NumStmt[:])\n",
+                v.Name, cv.File, c, c, c))
+        }
+    }
+
+    if len(covered) > 0 {
+        sbCover.Add("}\n")
+        sbCover.Add(coverSupport)
+        imprtSet.Add("import \"fmt\"\n")
+        imprtPaths.Add("fmt")
+    }
+
+    // TestMain, fuzzing and coverage need a testing.M + what 'go test'
+    // uses to make one, testing.Main knows nothing about them
+    mainStart := testMain != "" || nfuzz > 0 || len(covered) > 0
+
+    deps := "testdeps.TestDeps{}"
+    if len(covered) > 0 {
+        deps = "coverDeps{}"
+    }
+
+    if mainStart {
+        imprtSet.Remove("import \"regexp\"\n")
+        imprtPaths.Remove("regexp")
+        imprtSet.Add("import \"os\"\n")
+        imprtSet.Add("import \"testing/internal/testdeps\"\n")
+        imprtPaths.Add("os")
+        imprtPaths.Add("testing/internal/testdeps")
+    }
+
+    if testMain != "" {
+        imprtSet.Add("import \"reflect\"\n")
+        imprtPaths.Add("reflect")
+    }
+
+    sbTests.Add("};\n")
+    sbBench.Add("};\n")
+    sbExample.Add("};\n")
+    sbFuzz.Add("};\n")
+
+    if nfuzz > 0 {
+        sbFuzz.Add(fuzzInDir)
+    }
+
+    imports := imprtSet.Slice()
+    sort.Strings(imports)
+    for i := 0; i < len(imports); i++ {
+        sbImports.Add(imports[i])
+    }
+
+    sbTotal := stringbuffer.NewSize(sbImports.Len() +
+        sbTests.Len() +
+        sbBench.Len() +
+        sbExample.Len() +
+        sbFuzz.Len() +
+        sbCover.Len() + 100)
+    sbTotal.Add(sbImports.String())
+    sbTotal.Add(sbTests.String())
+    sbTotal.Add(sbBench.String())
+    sbTotal.Add(sbExample.String())
+
+    if mainStart {
+        sbTotal.Add(sbFuzz.String())
+    }
+
+    sbTotal.Add(sbCover.String())
+
+    sbTotal.Add("func main(){\n")
+    if testMain != "" {
+        sbTotal.Add("m := testing.MainStart(" + deps + ", tests, benchmarks, fuzzTargets, examples)\n")
+        sbTotal.Add(testMain + "(m)\n")
+        sbTotal.Add("os.Exit(int(reflect.ValueOf(m).Elem().FieldByName(\"exitCode\").Int()))\n}\n\n")
+    } else if mainStart {
+        sbTotal.Add("m := testing.MainStart(" + deps + ", tests, benchmarks, fuzzTargets, examples)\n")
+        sbTotal.Add("os.Exit(m.Run())\n}\n\n")
+    } else {
+        sbTotal.Add("testing.Main(regexp.MatchString, tests, benchmarks, examples);\n}\n\n")
+    }
+
+    return sbTotal.String(), imprtPaths
+}
+
+// fuzz targets read (and write) testdata/fuzz relative to where they run
+const fuzzInDir = `
+func inDir(dir string, fn func(*testing.F)) func(*testing.F) {
+    return func(f *testing.F) {
+        wd, _ := os.Getwd()
+        os.Chdir(dir)
+        defer os.Chdir(wd)
+        fn(f)
+    }
+}
+
+`
+
+// --cover: testing calls tearDown when the tests are done (even when
+// TestMain calls os.Exit), which prints the summary and writes the
+// profile in the format of 'go test -coverprofile'
+const coverSupport = `
+type coverDeps struct {
+    testdeps.TestDeps
+}
+
+func (coverDeps) InitRuntimeCoverage() (string, func(string, string) (string, error), func() float64) {
+    return "set", coverTearDown, coverTotal
+}
+
+var coverPackages []string
+var coverFiles = make(map[string][]string)
+var coverCounters = make(map[string][]uint32)
+var coverBlocks = make(map[string][]testing.CoverBlock)
+
+func coverRegister(pkg, file string, counter []uint32, pos []uint32, numStmts []uint16) {
+    if len(coverFiles[pkg]) == 0 {
+        coverPackages = append(coverPackages, pkg)
+    }
+    coverFiles[pkg] = append(coverFiles[pkg], file)
+    coverCounters[file] = counter
+    blocks := make([]testing.CoverBlock, len(counter))
+    for i := range counter {
+        blocks[i] = testing.CoverBlock{
+            Line0: pos[3*i+0],
+            Col0:  uint16(pos[3*i+2]),
+            Line1: pos[3*i+1],
+            Col1:  uint16(pos[3*i+2] >> 16),
+            Stmts: numStmts[i],
+        }
+    }
+    coverBlocks[file] = blocks
+}
+
+func coverPercent(files []string) float64 {
+    var total, active int64
+    for _, file := range files {
+        for i, block := range coverBlocks[file] {
+            total += int64(block.Stmts)
+            if coverCounters[file][i] > 0 {
+                active += int64(block.Stmts)
+            }
+        }
+    }
+    if total == 0 {
+        return 0
+    }
+    return float64(active) / float64(total)
+}
+
+func coverTotal() float64 {
+    files := make([]string, 0)
+    for _, pkg := range coverPackages {
+        files = append(files, coverFiles[pkg]...)
+    }
+    return coverPercent(files)
+}
+
+func coverTearDown(profile, gocoverdir string) (string, error) {
+    if len(coverPackages) == 1 {
+        fmt.Printf("coverage: %.1f%% of statements\n", 100*coverTotal())
+    } else {
+        for _, pkg := range coverPackages {
+            fmt.Printf("coverage: %.1f%% of statements in %s\n",
+                100*coverPercent(coverFiles[pkg]), pkg)
+        }
+    }
+    if profile == "" {
+        return "", nil
+    }
+    f, e := os.Create(profile)
+    if e != nil {
+        return "testing: cannot write coverprofile", e
+    }
+    defer f.Close()
+    fmt.Fprintf(f, "mode: set\n")
+    for _, pkg := range coverPackages {
+        for _, file := range coverFiles[pkg] {
+            for i, b := range coverBlocks[file] {
+                fmt.Fprintf(f, "%s:%d.%d,%d.%d %d %d\n", file,
+                    b.Line0, b.Col0, b.Line1, b.Col1, b.Stmts, coverCounters[file][i])
+            }
+        }
+    }
+    return "", nil
+}
+
+`
+
+// src-root/tmpNNN (+ lib-root/tmpNNN if objects live elsewhere)
+func makeTestDir(root, libroot string) (tmpstub, tmpdir, tmplib string) {
+
+    tmpstub = fmt.Sprintf("tmp%d", time.Now().Unix())
+    tmpdir = filepath.Join(root, tmpstub)
+    if libroot != "" {
+        tmplib = filepath.Join(libroot, tmpstub)
+    }
+
+    dir, e1 := os.Stat(tmpdir)
+
+    if e1 == nil && dir.IsDir() {
+        log.Printf("[ERROR] directory: %s already exists\n", tmpdir)
+    } else {
+        e_mk := os.Mkdir(tmpdir, 0777)
+        if e_mk != nil {
+            log.Fatal("[ERROR] failed to create directory for testing")
+        }
+    }
+
+    return tmpstub, tmpdir, tmplib
+}
+
+func writeTestMain(tmpfile, src string) {
+
+    fil, e2 := os.OpenFile(tmpfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+
+    if e2 != nil {
+        log.Fatalf("[ERROR] %s\n", e2)
+    }
+
+    n, e3 := fil.WriteString(src)
+
+    if e3 != nil {
+        log.Fatalf("[ERROR] %s\n", e3)
+    } else if n != len(src) {
+        log.Fatal("[ERROR] failed to write test")
+    }
+
+    fil.Close()
+}
+
+func testMainPackage(name, tmpfile string, imports *stringset.StringSet) *Package {
+    p := newPackage()
+    p.Name = name
+    p.ShortName = "main"
+    p.Files = append(p.Files, tmpfile)
+    p.srcdir = filepath.Dir(tmpfile)
+    p.dependencies = imports
+    return p
+}
+
+// parse/gopt => parse_gopt
+func testDirName(name string) string {
+    return strings.NewReplacer("/", "_", ".", "_").Replace(name)
+}
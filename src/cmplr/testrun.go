@@ -0,0 +1,239 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package compiler
+
+import (
+    "cmplr/dag"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+    "utilz/event"
+    "utilz/global"
+    "utilz/handy"
+    "utilz/say"
+    "utilz/semaphore"
+    "utilz/stringbuffer"
+)
+
+// One test binary per package (-per-package): binaries are linked and
+// run in parallel (at most -jobs at a time), each one from the source
+// directory of the package it tests, like 'go test ./...' does.
+
+type testResult struct {
+    ok      bool
+    elapsed time.Duration
+    stdout  string
+    stderr  string
+    suite   *testSuite // --test-report
+}
+
+// link a test binary for each harness, next to its _main.go, false
+// if some could not be linked
+func ForkLinkTests(mains, pkgs []*dag.Package) (binaries []string, ok bool) {
+
+    var extra []*dag.Package
+
+    switch global.GetString("-backend") {
+    case "gccgo", "gcc":
+        extra = pkgs
+    }
+
+    binaries = make([]string, len(mains))
+
+    wg := new(sync.WaitGroup)
+    slots := semaphore.New(global.GetInt("-jobs"))
+    failed := make(chan bool, len(mains))
+
+    for i := 0; i < len(mains); i++ {
+        binaries[i] = filepath.Join(filepath.Dir(mains[i].Files[0]), "gdtest")
+        if handy.GOOS() == "windows" {
+            binaries[i] += ".exe"
+        }
+        wg.Add(1)
+        go func(i int) {
+            slots.Acquire()
+            if !forkLink(binaries[i], mains[i], pkgs, extra, false) {
+                failed <- true
+            }
+            slots.Release()
+            wg.Done()
+        }(i)
+    }
+
+    wg.Wait()
+
+    return binaries, len(failed) == 0
+}
+
+// run binaries[i] in the directory of tested[i], print a line per
+// package as results come in (in order), false if anything failed
+func RunTests(tested []*dag.Package, binaries []string) bool {
+
+    if global.GetBool("-dryrun") {
+        for i := 0; i < len(tested); i++ {
+            argv := packageTestArgv(binaries[i])
+            event.Dryrun(&event.Event{Package: tested[i].Name, Dir: testDir(tested[i]), Argv: argv},
+                fmt.Sprintf("(cd %s && %s) || exit 1\n", testDir(tested[i]), strings.Join(argv, " ")))
+        }
+        return true
+    }
+
+    results := make([]chan *testResult, len(tested))
+    slots := semaphore.New(global.GetInt("-jobs"))
+
+    for i := 0; i < len(tested); i++ {
+        results[i] = make(chan *testResult, 1)
+        go func(i int) {
+            slots.Acquire()
+            results[i] <- runTest(tested[i], packageTestArgv(binaries[i]))
+            slots.Release()
+        }(i)
+    }
+
+    ok := true
+
+    for i := 0; i < len(tested); i++ {
+        r := <-results[i]
+        reportTest(tested[i], binaries[i], r)
+        ok = ok && r.ok
+        if r.suite != nil {
+            suites = append(suites, r.suite)
+        }
+    }
+
+    WriteTestReport()
+
+    if global.GetString("-coverprofile") != "" {
+        mergeProfiles(global.GetString("-coverprofile"), binaries)
+    }
+
+    return ok
+}
+
+// each binary writes its own coverage profile, see mergeProfiles
+func packageTestArgv(binary string) []string {
+    if global.GetString("-coverprofile") != "" {
+        return testArgv(binary, binary+".cover")
+    }
+    return testArgv(binary, "")
+}
+
+// one profile, one mode line, like 'go test ./... -coverprofile'
+func mergeProfiles(profile string, binaries []string) {
+
+    sb := stringbuffer.New()
+    sb.Add("mode: set\n")
+
+    for i := 0; i < len(binaries); i++ {
+        content, e := ioutil.ReadFile(binaries[i] + ".cover")
+        if e != nil {
+            continue // test binary died before writing it
+        }
+        for _, line := range strings.SplitAfter(string(content), "\n") {
+            if line != "" && !strings.HasPrefix(line, "mode: ") {
+                sb.Add(line)
+            }
+        }
+    }
+
+    e := ioutil.WriteFile(profile, sb.Bytes(), 0644)
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+}
+
+// 'coverage: 57.1% of statements' lines printed by a test binary
+func coverSummary(stdout string) string {
+
+    lines := make([]string, 0)
+
+    for _, line := range strings.Split(stdout, "\n") {
+        if strings.HasPrefix(line, "coverage: ") {
+            lines = append(lines, line)
+        }
+    }
+
+    if len(lines) == 0 {
+        return ""
+    }
+
+    return "\t" + strings.Join(lines, "\n\t\t\t")
+}
+
+func runTest(pkg *dag.Package, argv []string) *testResult {
+
+    if Reporting() {
+        argv = reportArgv(argv)
+    }
+
+    start := time.Now()
+    stdout, stderr, status, e := handy.CaptureIn(testDir(pkg), argv)
+
+    r := &testResult{
+        ok:      e == nil,
+        elapsed: time.Since(start),
+        stdout:  stdout,
+        stderr:  stderr,
+    }
+
+    if Reporting() {
+        reportedTest(pkg.Name, r, status, e)
+    }
+
+    return r
+}
+
+func reportTest(pkg *dag.Package, binary string, r *testResult) {
+
+    if event.Enabled() {
+        status := 0
+        result := "ok"
+        if !r.ok {
+            status = 1
+            result = "fail"
+        }
+        event.Emit(&event.Event{Action: "test", Package: pkg.Name,
+            Output: binary, Argv: packageTestArgv(binary),
+            Elapsed: r.elapsed.Seconds(), Exit: &status, Result: result,
+            Stdout: r.stdout, Stderr: r.stderr})
+        return
+    }
+
+    verbose := global.GetBool("-verbose") || global.GetBool("-test.v")
+
+    if r.ok {
+        if verbose {
+            say.Printf("%s%s", r.stdout, r.stderr)
+        }
+        say.Printf("ok  \t%s\t%.3fs%s\n", pkg.Name, r.elapsed.Seconds(),
+            coverSummary(r.stdout))
+    } else {
+        // errors are printed even when -quiet
+        fmt.Fprintf(os.Stderr, "%s%s", r.stdout, r.stderr)
+        fmt.Fprintf(os.Stderr, "FAIL\t%s\t%.3fs\n", pkg.Name, r.elapsed.Seconds())
+    }
+}
+
+// tests run from the directory holding the package source
+func testDir(pkg *dag.Package) string {
+    return pkg.SrcDir()
+}
@@ -0,0 +1,300 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gomod
+
+/*
+
+Just enough of go.mod to build a module without the go tool:
+
+  module example.com/m
+
+  go 1.21
+
+  require (
+      github.com/a/b v1.2.3
+      github.com/c/d v0.1.0 // indirect
+  )
+
+The module path is the prefix of all local packages, the require
+directives tell us which imports belong to other modules, and where
+to find them in the module cache ($GOMODCACHE):
+
+  github.com/a/b/sub => $GOMODCACHE/github.com/a/b@v1.2.3/sub
+
+Other directives (replace, exclude, retract..) are ignored.
+
+*/
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "unicode"
+    "utilz/handy"
+)
+
+// import classification
+const (
+    Unknown = iota
+    Local
+    Std
+    Required
+)
+
+type Require struct {
+    Path     string
+    Version  string
+    Indirect bool
+}
+
+type Module struct {
+    Path     string
+    Go       string
+    Dir      string // directory holding go.mod
+    Requires []*Require
+}
+
+// look for go.mod in dir and its parents, "" if there is none
+func Find(dir string) string {
+
+    dir, e := filepath.Abs(dir)
+
+    if e != nil {
+        return ""
+    }
+
+    for {
+        gomod := filepath.Join(dir, "go.mod")
+        if handy.IsFile(gomod) {
+            return gomod
+        }
+        parent := filepath.Dir(dir)
+        if parent == dir {
+            return ""
+        }
+        dir = parent
+    }
+}
+
+func Parse(pathname string) (*Module, error) {
+
+    content, e := ioutil.ReadFile(pathname)
+
+    if e != nil {
+        return nil, e
+    }
+
+    m, e := ParseContent(string(content))
+
+    if e != nil {
+        return nil, fmt.Errorf("%s:%s", pathname, e)
+    }
+
+    m.Dir = filepath.Dir(pathname)
+
+    return m, nil
+}
+
+func ParseContent(content string) (*Module, error) {
+
+    var block string // directive of current ( .. ) block
+
+    m := new(Module)
+    m.Requires = make([]*Require, 0)
+
+    lines := strings.Split(content, "\n")
+
+    for i := 0; i < len(lines); i++ {
+
+        line, comment := splitComment(lines[i])
+        fields := strings.Fields(line)
+
+        if len(fields) == 0 {
+            continue
+        }
+
+        if block != "" {
+            if fields[0] == ")" {
+                block = ""
+                continue
+            }
+            fields = append([]string{block}, fields...)
+        } else if len(fields) == 2 && fields[1] == "(" {
+            block = fields[0]
+            continue
+        }
+
+        switch fields[0] {
+        case "module":
+            if len(fields) != 2 {
+                return nil, fmt.Errorf("%d: usage: module path", i+1)
+            }
+            m.Path = unquote(fields[1])
+        case "go":
+            if len(fields) == 2 {
+                m.Go = fields[1]
+            }
+        case "require":
+            if len(fields) != 3 {
+                return nil, fmt.Errorf("%d: usage: require path version", i+1)
+            }
+            r := &Require{Path: unquote(fields[1]), Version: unquote(fields[2])}
+            r.Indirect = strings.TrimSpace(comment) == "indirect"
+            m.Requires = append(m.Requires, r)
+        }
+    }
+
+    if m.Path == "" {
+        return nil, fmt.Errorf(" missing module directive")
+    }
+
+    return m, nil
+}
+
+func splitComment(line string) (code, comment string) {
+    i := strings.Index(line, "//")
+    if i < 0 {
+        return line, ""
+    }
+    return line[:i], line[i+2:]
+}
+
+func unquote(s string) string {
+    if u, e := strconv.Unquote(s); e == nil {
+        return u
+    }
+    return s
+}
+
+// local, standard library, required module or unknown
+func (m *Module) Classify(imprt string) int {
+
+    if within(imprt, m.Path) {
+        return Local
+    }
+
+    if m.Require(imprt) != nil {
+        return Required
+    }
+
+    if IsStd(imprt) {
+        return Std
+    }
+
+    return Unknown
+}
+
+// standard library paths have no dot in the first element
+func IsStd(imprt string) bool {
+    first := strings.SplitN(imprt, "/", 2)[0]
+    return !strings.Contains(first, ".")
+}
+
+// the required module providing imprt, longest module path wins
+func (m *Module) Require(imprt string) *Require {
+
+    var best *Require
+
+    for i := 0; i < len(m.Requires); i++ {
+        if within(imprt, m.Requires[i].Path) {
+            if best == nil || len(m.Requires[i].Path) > len(best.Path) {
+                best = m.Requires[i]
+            }
+        }
+    }
+
+    return best
+}
+
+// source directory of a package from a required module
+func (m *Module) PackageDir(imprt string) (dir string, ok bool) {
+
+    r := m.Require(imprt)
+
+    if r == nil {
+        return "", false
+    }
+
+    dir = filepath.Join(ModCache(), escape(r.Path)+"@"+escape(r.Version))
+    rest := strings.TrimPrefix(imprt[len(r.Path):], "/")
+
+    if rest != "" {
+        dir = filepath.Join(dir, filepath.FromSlash(rest))
+    }
+
+    return dir, handy.IsDir(dir)
+}
+
+// import path of a local package in directory dir
+func (m *Module) ImportPath(dir string) string {
+
+    abs, e := filepath.Abs(dir)
+
+    if e != nil {
+        return ""
+    }
+
+    rel, e := filepath.Rel(m.Dir, abs)
+
+    if e != nil || rel == "." {
+        return m.Path
+    }
+
+    return m.Path + "/" + filepath.ToSlash(rel)
+}
+
+func within(imprt, prefix string) bool {
+    return imprt == prefix || strings.HasPrefix(imprt, prefix+"/")
+}
+
+// $GOMODCACHE, or the first $GOPATH entry + pkg/mod
+func ModCache() string {
+
+    if cache := os.Getenv("GOMODCACHE"); cache != "" {
+        return cache
+    }
+
+    if gopath := handy.GOPATH(); len(gopath) > 0 && gopath[0] != "" {
+        return filepath.Join(gopath[0], "pkg", "mod")
+    }
+
+    home, e := os.UserHomeDir()
+
+    if e != nil {
+        return ""
+    }
+
+    return filepath.Join(home, "go", "pkg", "mod")
+}
+
+// upper case letters are escaped in the module cache: 'A' => '!a'
+func escape(s string) string {
+
+    var out []rune
+
+    for _, r := range s {
+        if unicode.IsUpper(r) {
+            out = append(out, '!', unicode.ToLower(r))
+        } else {
+            out = append(out, r)
+        }
+    }
+
+    return string(out)
+}
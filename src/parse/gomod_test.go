@@ -0,0 +1,108 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gomod_test
+
+import (
+    "os"
+    "parse/gomod"
+    "path/filepath"
+    "testing"
+)
+
+const content = `
+module example.com/m // comment
+
+go 1.21
+
+require github.com/a/b v1.2.3
+
+require (
+    github.com/a/b/v2 v2.0.1
+    github.com/BurntSushi/toml v0.3.1 // indirect
+)
+
+replace github.com/a/b => ../b
+`
+
+func TestParseContent(t *testing.T) {
+
+    m, e := gomod.ParseContent(content)
+
+    if e != nil {
+        t.Fatalf("gomod.ParseContent: %s\n", e)
+    }
+
+    if m.Path != "example.com/m" || m.Go != "1.21" {
+        t.Fatalf("module: '%s' go: '%s'\n", m.Path, m.Go)
+    }
+
+    if len(m.Requires) != 3 {
+        t.Fatalf("len(m.Requires) != 3 (%d)\n", len(m.Requires))
+    }
+
+    r := m.Requires[2]
+
+    if r.Path != "github.com/BurntSushi/toml" || r.Version != "v0.3.1" || !r.Indirect {
+        t.Fatalf("require: %v\n", r)
+    }
+
+    _, e = gomod.ParseContent("go 1.21\n")
+
+    if e == nil {
+        t.Fatalf("missing module directive should fail\n")
+    }
+}
+
+func TestClassify(t *testing.T) {
+
+    m, _ := gomod.ParseContent(content)
+
+    kinds := map[string]int{
+        "example.com/m":                     gomod.Local,
+        "example.com/m/sub/pkg":             gomod.Local,
+        "example.com/mm":                    gomod.Unknown,
+        "fmt":                               gomod.Std,
+        "net/http":                          gomod.Std,
+        "github.com/a/b/c":                  gomod.Required,
+        "github.com/a/bc":                   gomod.Unknown,
+        "github.com/BurntSushi/toml":        gomod.Required,
+        "golang.org/x/tools/go/ast/astutil": gomod.Unknown,
+    }
+
+    for imprt, kind := range kinds {
+        if m.Classify(imprt) != kind {
+            t.Fatalf("Classify(%s) = %d != %d\n", imprt, m.Classify(imprt), kind)
+        }
+    }
+
+    if m.Require("github.com/a/b/v2/x").Version != "v2.0.1" {
+        t.Fatalf("longest module path should win\n")
+    }
+}
+
+func TestPackageDir(t *testing.T) {
+
+    m, _ := gomod.ParseContent(content)
+
+    os.Setenv("GOMODCACHE", "/cache")
+
+    dir, _ := m.PackageDir("github.com/BurntSushi/toml/internal")
+    want := filepath.Join("/cache", "github.com/!burnt!sushi/toml@v0.3.1/internal")
+
+    if dir != want {
+        t.Fatalf("%s != %s\n", dir, want)
+    }
+}
@@ -0,0 +1,331 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query
+
+/*
+
+Expressions asking for sets of packages, a bit like bazel query:
+
+  expr := term { op term }
+  term := word | string | func '(' expr { ',' expr } ')' | '(' expr ')'
+  op   := '+' | 'union' | '^' | 'intersect' | '-' | 'except'
+
+Operators have the same precedence and group to the left, use
+parentheses for anything else. A word is a package name, or a
+pattern ending in '...' (utilz/... => utilz and everything below);
+words end at white space and any of: ( ) , + ^ " '
+
+Functions:
+
+  deps(x [, depth])      x and what it imports, recursively
+  rdeps(x [, depth])     x and what imports it, recursively
+  allpaths(from, to)     packages on some path from -> to
+  somepath(from, to)     one path from -> to, shortest first
+  filter(regex, x)       packages in x with a name matching regex
+  local(x)               packages in x found in the source tree
+
+*/
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// set operators, Binary.Op
+const (
+    Union     = "union"
+    Intersect = "intersect"
+    Except    = "except"
+)
+
+type Expr interface {
+    String() string
+}
+
+// package name, pattern, regex or depth
+type Word struct {
+    Name string
+}
+
+type Call struct {
+    Func string
+    Args []Expr
+}
+
+type Binary struct {
+    Op          string
+    Left, Right Expr
+}
+
+// number of arguments: min, max
+var functions = map[string][2]int{
+    "deps":     {1, 2},
+    "rdeps":    {1, 2},
+    "allpaths": {2, 2},
+    "somepath": {2, 2},
+    "filter":   {2, 2},
+    "local":    {1, 1},
+}
+
+var operators = map[string]string{
+    "+":         Union,
+    "union":     Union,
+    "^":         Intersect,
+    "intersect": Intersect,
+    "-":         Except,
+    "except":    Except,
+}
+
+func (w *Word) String() string {
+    return w.Name
+}
+
+func (c *Call) String() string {
+    args := make([]string, len(c.Args))
+    for i := 0; i < len(c.Args); i++ {
+        args[i] = c.Args[i].String()
+    }
+    return c.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (b *Binary) String() string {
+    return "(" + b.Left.String() + " " + b.Op + " " + b.Right.String() + ")"
+}
+
+// Depth of deps/rdeps, -1 => no limit
+func (c *Call) Depth() int {
+    if len(c.Args) < 2 {
+        return -1
+    }
+    depth, _ := strconv.Atoi(c.Args[1].String()) // checked by Parse
+    return depth
+}
+
+type token struct {
+    text   string
+    pos    int
+    quoted bool
+}
+
+type parser struct {
+    src    string
+    tokens []*token
+    next   int
+}
+
+func Parse(src string) (Expr, error) {
+
+    tokens, e := scan(src)
+
+    if e != nil {
+        return nil, e
+    }
+
+    p := &parser{src: src, tokens: tokens}
+
+    expr, e := p.expr()
+
+    if e != nil {
+        return nil, e
+    }
+
+    if t := p.peek(); t != nil {
+        return nil, p.errorf(t, "unexpected '%s'", t.text)
+    }
+
+    return expr, nil
+}
+
+func scan(src string) ([]*token, error) {
+
+    tokens := make([]*token, 0)
+
+    for i := 0; i < len(src); {
+
+        switch c := src[i]; {
+
+        case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+            i++
+
+        case strings.IndexByte("(),+^", c) >= 0:
+            tokens = append(tokens, &token{text: src[i : i+1], pos: i})
+            i++
+
+        case c == '"' || c == '\'':
+            end := strings.IndexByte(src[i+1:], c)
+            if end < 0 {
+                return nil, fmt.Errorf("query: missing %c at %d: %s", c, len(src), src)
+            }
+            tokens = append(tokens, &token{text: src[i+1 : i+1+end], pos: i, quoted: true})
+            i += end + 2
+
+        default:
+            start := i
+            for i < len(src) && strings.IndexByte(" \t\n\r(),+^\"'", src[i]) < 0 {
+                i++
+            }
+            tokens = append(tokens, &token{text: src[start:i], pos: start})
+        }
+    }
+
+    return tokens, nil
+}
+
+func (p *parser) peek() *token {
+    if p.next < len(p.tokens) {
+        return p.tokens[p.next]
+    }
+    return nil
+}
+
+func (p *parser) pop() *token {
+    t := p.peek()
+    if t != nil {
+        p.next++
+    }
+    return t
+}
+
+func (p *parser) errorf(t *token, frmt string, args ...interface{}) error {
+    pos := len(p.src)
+    if t != nil {
+        pos = t.pos
+    }
+    return fmt.Errorf("query: %s at %d: %s", fmt.Sprintf(frmt, args...), pos, p.src)
+}
+
+// the next token must be text
+func (p *parser) expect(text string) error {
+    t := p.pop()
+    if t == nil {
+        return p.errorf(nil, "missing '%s'", text)
+    }
+    if t.quoted || t.text != text {
+        return p.errorf(t, "expected '%s', found '%s'", text, t.text)
+    }
+    return nil
+}
+
+func (p *parser) expr() (Expr, error) {
+
+    left, e := p.term()
+
+    if e != nil {
+        return nil, e
+    }
+
+    for {
+        t := p.peek()
+        if t == nil || t.quoted || operators[t.text] == "" {
+            return left, nil
+        }
+        p.pop()
+        right, e := p.term()
+        if e != nil {
+            return nil, e
+        }
+        left = &Binary{Op: operators[t.text], Left: left, Right: right}
+    }
+}
+
+func (p *parser) term() (Expr, error) {
+
+    t := p.pop()
+
+    switch {
+    case t == nil:
+        return nil, p.errorf(nil, "missing expression")
+    case t.quoted:
+        return &Word{Name: t.text}, nil
+    case t.text == "(":
+        expr, e := p.expr()
+        if e != nil {
+            return nil, e
+        }
+        return expr, p.expect(")")
+    case strings.IndexByte("),+^", t.text[0]) >= 0 || operators[t.text] != "":
+        return nil, p.errorf(t, "unexpected '%s'", t.text)
+    }
+
+    // function call or word
+    if next := p.peek(); next == nil || next.quoted || next.text != "(" {
+        return &Word{Name: t.text}, nil
+    }
+
+    arity, ok := functions[t.text]
+
+    if !ok {
+        return nil, p.errorf(t, "unknown function '%s'", t.text)
+    }
+
+    p.pop() // (
+
+    call := &Call{Func: t.text, Args: make([]Expr, 0)}
+
+    for {
+        arg, e := p.expr()
+        if e != nil {
+            return nil, e
+        }
+        call.Args = append(call.Args, arg)
+        if next := p.peek(); next != nil && !next.quoted && next.text == "," {
+            p.pop()
+            continue
+        }
+        if e = p.expect(")"); e != nil {
+            return nil, e
+        }
+        break
+    }
+
+    if len(call.Args) < arity[0] || len(call.Args) > arity[1] {
+        want := strconv.Itoa(arity[0])
+        if arity[1] > arity[0] {
+            want += " or " + strconv.Itoa(arity[1])
+        }
+        return nil, p.errorf(t, "%s takes %s arguments, got %d",
+            t.text, want, len(call.Args))
+    }
+
+    return call, checkArgs(p, t, call)
+}
+
+// literal arguments: depth of deps/rdeps, regex of filter
+func checkArgs(p *parser, t *token, call *Call) error {
+
+    switch call.Func {
+    case "deps", "rdeps":
+        if len(call.Args) == 2 {
+            n, e := strconv.Atoi(call.Args[1].String())
+            if _, ok := call.Args[1].(*Word); !ok || e != nil || n < 0 {
+                return p.errorf(t, "%s: depth must be a number >= 0, got '%s'",
+                    call.Func, call.Args[1])
+            }
+        }
+    case "filter":
+        regex, ok := call.Args[0].(*Word)
+        if !ok {
+            return p.errorf(t, "filter: regex expected, got '%s'", call.Args[0])
+        }
+        if _, e := regexp.Compile(regex.Name); e != nil {
+            return p.errorf(t, "filter: %s", e)
+        }
+    }
+
+    return nil
+}
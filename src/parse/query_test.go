@@ -0,0 +1,96 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query_test
+
+import (
+    "parse/query"
+    "testing"
+)
+
+func TestParse(t *testing.T) {
+
+    // expression => String() of what is parsed
+    ok := map[string]string{
+        "utilz/handy":                     "utilz/handy",
+        "utilz/...":                       "utilz/...",
+        "deps(start/main)":                "deps(start/main)",
+        "deps(start/main, 1)":             "deps(start/main, 1)",
+        "rdeps( fmt )":                    "rdeps(fmt)",
+        "a + b - c":                       "((a union b) except c)",
+        "a union b except c":              "((a union b) except c)",
+        "a ^ (b + c)":                     "(a intersect (b union c))",
+        "go-foo/foo-bar - x":              "(go-foo/foo-bar except x)",
+        "allpaths(a, b) ^ local(...)":     "(allpaths(a, b) intersect local(...))",
+        "somepath(deps(a), b)":            "somepath(deps(a), b)",
+        `filter("^utilz/(handy|say)$", ...)`: "filter(^utilz/(handy|say)$, ...)",
+        "filter('[a-z]+', rdeps(x, 2))":   "filter([a-z]+, rdeps(x, 2))",
+        "'union' + x":                     "(union union x)",
+    }
+
+    for src, want := range ok {
+        expr, e := query.Parse(src)
+        if e != nil {
+            t.Fatalf("query.Parse(%s): %s\n", src, e)
+        }
+        if expr.String() != want {
+            t.Fatalf("query.Parse(%s): %s != %s\n", src, expr, want)
+        }
+    }
+
+    bad := []string{
+        "",
+        "a +",
+        "+ a",
+        "(a + b",
+        "a b",
+        "a )",
+        "nosuch(a)",
+        "deps()",
+        "deps(a, 1, 2)",
+        "deps(a, b)",
+        "rdeps(a, -1)",
+        "allpaths(a)",
+        "filter(deps(a), b)",
+        "filter('(', b)",
+        "local(a, b)",
+        `"a`,
+    }
+
+    for i := 0; i < len(bad); i++ {
+        if _, e := query.Parse(bad[i]); e == nil {
+            t.Fatalf("query.Parse(%s) should fail\n", bad[i])
+        }
+    }
+}
+
+func TestDepth(t *testing.T) {
+
+    depths := map[string]int{
+        "deps(a)":     -1,
+        "deps(a, 0)":  0,
+        "rdeps(a, 3)": 3,
+    }
+
+    for src, want := range depths {
+        expr, e := query.Parse(src)
+        if e != nil {
+            t.Fatalf("query.Parse(%s): %s\n", src, e)
+        }
+        if depth := expr.(*query.Call).Depth(); depth != want {
+            t.Fatalf("%s: depth %d != %d\n", src, depth, want)
+        }
+    }
+}
@@ -0,0 +1,279 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tags
+
+/*
+
+Decide if a source file belongs in the build for a given target,
+the same way the go tool does it:
+
+  - filename suffixes: name_GOOS.go, name_GOARCH.go, name_GOOS_GOARCH.go
+    (a _test suffix is stripped first)
+  - build constraint lines at the top of the file, before the
+    package clause: //go:build expr, or the old // +build lines
+
+Satisfied tags are: target GOOS and GOARCH, 'unix' for unix like
+systems, the compiler (gc/gccgo), 'cgo' if enabled, go1.x release
+tags and any tag given with --tags.
+
+*/
+
+import (
+    "bufio"
+    "go/build/constraint"
+    "go/parser"
+    "go/token"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strconv"
+    "strings"
+)
+
+var goos string = runtime.GOOS
+var goarch string = runtime.GOARCH
+var compiler string = runtime.Compiler
+var extra = make(map[string]bool)
+var cgo bool // false
+
+var knownOS = map[string]bool{
+    "aix": true, "android": true, "darwin": true, "dragonfly": true,
+    "freebsd": true, "hurd": true, "illumos": true, "ios": true,
+    "js": true, "linux": true, "nacl": true, "netbsd": true,
+    "openbsd": true, "plan9": true, "solaris": true, "wasip1": true,
+    "windows": true, "zos": true,
+}
+
+var knownArch = map[string]bool{
+    "386": true, "amd64": true, "amd64p32": true, "arm": true,
+    "armbe": true, "arm64": true, "arm64be": true, "loong64": true,
+    "mips": true, "mipsle": true, "mips64": true, "mips64le": true,
+    "mips64p32": true, "mips64p32le": true, "ppc": true, "ppc64": true,
+    "ppc64le": true, "riscv": true, "riscv64": true, "s390": true,
+    "s390x": true, "sparc": true, "sparc64": true, "wasm": true,
+}
+
+var unixOS = map[string]bool{
+    "aix": true, "android": true, "darwin": true, "dragonfly": true,
+    "freebsd": true, "hurd": true, "illumos": true, "ios": true,
+    "linux": true, "netbsd": true, "openbsd": true, "solaris": true,
+}
+
+// target platform + user defined tags (--tags a,b or "a b")
+func Init(targetOS, targetArch, backend, usertags string) {
+
+    goos = targetOS
+    goarch = targetArch
+    compiler = backend
+    // cgo needs the go backend, and a C compiler for the target
+    cgo = backend == "go" && targetOS == runtime.GOOS && targetArch == runtime.GOARCH
+    switch os.Getenv("CGO_ENABLED") {
+    case "0":
+        cgo = false
+    case "1":
+        cgo = backend == "go"
+    }
+
+    switch compiler {
+    case "gcc":
+        compiler = "gccgo"
+    case "go":
+        compiler = "gc"
+    }
+
+    extra = make(map[string]bool)
+
+    fn := func(r rune) bool { return r == ',' || r == ' ' }
+
+    for _, t := range strings.FieldsFunc(usertags, fn) {
+        extra[t] = true
+    }
+}
+
+// filename and build constraints both have to be satisfied,
+// files importing "C" are left out when cgo is disabled
+func Match(pathname string) bool {
+    return MatchName(filepath.Base(pathname)) && MatchContent(pathname) &&
+        (cgo || !importsC(pathname))
+}
+
+// true if cgo is enabled for this build
+func Cgo() bool {
+    return cgo
+}
+
+func importsC(pathname string) bool {
+
+    tree, e := parser.ParseFile(token.NewFileSet(), pathname, nil, parser.ImportsOnly)
+
+    if e != nil {
+        return false
+    }
+
+    for _, spec := range tree.Imports {
+        if spec.Path.Value == `"C"` {
+            return true
+        }
+    }
+
+    return false
+}
+
+func MatchName(name string) bool {
+
+    name = strings.TrimSuffix(name, ".go")
+    name = strings.TrimSuffix(name, "_test")
+
+    toks := strings.Split(name, "_")
+
+    // first token is never a constraint: linux.go is just a file
+    if len(toks) < 2 {
+        return true
+    }
+
+    last := toks[len(toks)-1]
+
+    if len(toks) >= 3 && knownOS[toks[len(toks)-2]] && knownArch[last] {
+        return toks[len(toks)-2] == goos && last == goarch
+    }
+
+    if knownOS[last] {
+        return last == goos || (last == "linux" && goos == "android") ||
+            (last == "darwin" && goos == "ios") ||
+            (last == "solaris" && goos == "illumos")
+    }
+
+    if knownArch[last] {
+        return last == goarch
+    }
+
+    return true
+}
+
+// look for build constraints in the comments above the package clause
+func MatchContent(pathname string) bool {
+
+    fd, e := os.Open(pathname)
+
+    if e != nil {
+        return true // let the parser complain about this later
+    }
+
+    defer fd.Close()
+
+    var goBuild constraint.Expr
+    var plusBuild []constraint.Expr
+
+    scanner := bufio.NewScanner(fd)
+
+    for scanner.Scan() {
+
+        line := strings.TrimSpace(scanner.Text())
+
+        if line == "" {
+            continue
+        }
+
+        if !strings.HasPrefix(line, "//") {
+            break
+        }
+
+        if constraint.IsGoBuild(line) && goBuild == nil {
+            goBuild, e = constraint.Parse(line)
+            if e != nil {
+                return false
+            }
+        } else if constraint.IsPlusBuild(line) {
+            expr, e := constraint.Parse(line)
+            if e != nil {
+                return false
+            }
+            plusBuild = append(plusBuild, expr)
+        }
+    }
+
+    // //go:build wins, // +build lines must all be satisfied
+    if goBuild != nil {
+        return goBuild.Eval(satisfied)
+    }
+
+    for i := 0; i < len(plusBuild); i++ {
+        if !plusBuild[i].Eval(satisfied) {
+            return false
+        }
+    }
+
+    return true
+}
+
+// old style constraint: space separated alternatives of comma
+// separated terms, '#cgo linux,!arm CFLAGS: ..' uses these
+func MatchExpr(expr string) bool {
+
+    if strings.TrimSpace(expr) == "" {
+        return true
+    }
+
+    e, err := constraint.Parse("// +build " + expr)
+
+    if err != nil {
+        return false
+    }
+
+    return e.Eval(satisfied)
+}
+
+func satisfied(tag string) bool {
+
+    switch {
+    case tag == goos, tag == goarch, tag == compiler, extra[tag]:
+        return true
+    case tag == "cgo":
+        return cgo
+    case tag == "unix":
+        return unixOS[goos]
+    case tag == "linux":
+        return goos == "android"
+    case tag == "darwin":
+        return goos == "ios"
+    case tag == "solaris":
+        return goos == "illumos"
+    case strings.HasPrefix(tag, "go1."):
+        return releaseTag(tag)
+    }
+
+    return false
+}
+
+// go1.N is satisfied by every toolchain from go1.N and up
+func releaseTag(tag string) bool {
+
+    minor, e := strconv.Atoi(strings.TrimPrefix(tag, "go1."))
+
+    if e != nil {
+        return false
+    }
+
+    version := strings.TrimPrefix(runtime.Version(), "go1.")
+    version = strings.SplitN(version, ".", 2)[0]
+    current, e := strconv.Atoi(version)
+
+    if e != nil {
+        return true // devel version, assume it's new
+    }
+
+    return minor <= current
+}
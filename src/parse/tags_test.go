@@ -0,0 +1,104 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tags_test
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "parse/tags"
+    "testing"
+)
+
+func TestMatchName(t *testing.T) {
+
+    tags.Init("linux", "amd64", "gc", "")
+
+    ok := []string{"a.go", "linux.go", "a_linux.go", "a_amd64.go",
+        "a_linux_amd64.go", "a_linux_test.go", "a_b.go", "a_unix.go"}
+
+    for i := 0; i < len(ok); i++ {
+        if !tags.MatchName(ok[i]) {
+            t.Fatalf("! tags.MatchName('%s')\n", ok[i])
+        }
+    }
+
+    bad := []string{"a_windows.go", "a_arm.go", "a_linux_arm.go",
+        "a_windows_amd64.go", "a_darwin_test.go"}
+
+    for i := 0; i < len(bad); i++ {
+        if tags.MatchName(bad[i]) {
+            t.Fatalf("tags.MatchName('%s')\n", bad[i])
+        }
+    }
+}
+
+func TestMatchContent(t *testing.T) {
+
+    dir, e := ioutil.TempDir("", "godag")
+
+    if e != nil {
+        t.Fatalf("%s\n", e)
+    }
+
+    defer os.RemoveAll(dir)
+
+    files := map[string]bool{
+        "//go:build linux && !cgo\n\npackage a\n":       true,
+        "//go:build windows\n\npackage a\n":             false,
+        "// +build linux darwin\n\npackage a\n":         true,
+        "// +build linux\n// +build arm\n\npackage a\n": false,
+        "//go:build mytag\n\npackage a\n":               true,
+        "//go:build go1.1\n\npackage a\n":               true,
+        "package a\n\n//go:build windows\n":             true,
+    }
+
+    tags.Init("linux", "amd64", "gc", "mytag,other")
+
+    i := 0
+    for content, want := range files {
+        i++
+        pathname := filepath.Join(dir, "f"+string(rune('a'+i))+".go")
+        e = ioutil.WriteFile(pathname, []byte(content), 0644)
+        if e != nil {
+            t.Fatalf("%s\n", e)
+        }
+        if tags.MatchContent(pathname) != want {
+            t.Fatalf("tags.MatchContent(%q) != %v\n", content, want)
+        }
+    }
+}
+
+func TestMatchExpr(t *testing.T) {
+
+    tags.Init("linux", "amd64", "gc", "")
+
+    exprs := map[string]bool{
+        "":               true,
+        "linux":          true,
+        "linux,!arm":     true,
+        "windows darwin": false,
+        "windows linux":  true,
+        "linux,arm":      false,
+        "!cgo":           true,
+    }
+
+    for expr, want := range exprs {
+        if tags.MatchExpr(expr) != want {
+            t.Fatalf("tags.MatchExpr(%q) != %v\n", expr, want)
+        }
+    }
+}
@@ -22,10 +22,15 @@ import (
     "fmt"
     "log"
     "os"
+    "parse/gomod"
     "parse/gopt"
+    "parse/query"
+    "parse/tags"
     "path/filepath"
     "runtime"
+    "strconv"
     "strings"
+    "utilz/event"
     "utilz/global"
     "utilz/handy"
     "utilz/say"
@@ -67,6 +72,14 @@ var bools = []string{
     "-test.short",
     "-test.v",
     "-strip",
+    "-keep-going",
+    "-json",
+    "-vendor-only",
+    "-per-package",
+    "-cover",
+    "-watch",
+    "-serve",
+    "-client",
 }
 
 // keys for the string options
@@ -77,6 +90,16 @@ var strs = []string{
     "-rewrite",
     "-output",
     "-bench",
+    "-fuzz",
+    "-fuzztime",
+    "-coverprofile",
+    "-coverpkg",
+    "-since",
+    "-changed",
+    "-test-report",
+    "-importers",
+    "-query",
+    "-query-format",
     "-match",
     "-test-bin",
     "-lib",
@@ -84,6 +107,10 @@ var strs = []string{
     "-backend",
     "-gdmk",
     "-mkcomplete",
+    "-jobs",
+    "-tags",
+    "-goos",
+    "-goarch",
     // add missing test options + alias
     "-test.bench",
     "-test.benchtime",
@@ -116,6 +143,13 @@ func init() {
     getopt.BoolOption("-T -tab --tab")
     getopt.BoolOption("-a -all --all")
     getopt.BoolOption("-y -strip --strip strip")
+    getopt.BoolOption("-k -keep-going --keep-going")
+    getopt.BoolOption("-json --json")
+    getopt.BoolOption("-vendor-only --vendor-only")
+    getopt.BoolOption("-per-package --per-package")
+    getopt.BoolOption("-W -watch --watch")
+    getopt.BoolOption("-serve --serve")
+    getopt.BoolOption("-client --client")
     getopt.BoolOption("-e -external --external")
     getopt.BoolOption("-u -updatex --updatex "+
                       "-update-external --update-external")
@@ -129,9 +163,24 @@ func init() {
     getopt.StringOptionFancy("-o --output")
     getopt.StringOptionFancy("-M --main")
     getopt.StringOptionFancy("-b --bench")
+    getopt.StringOptionFancy("--fuzz")
+    getopt.StringOptionFancy("--fuzztime")
+    getopt.BoolOption("-cover --cover")
+    getopt.StringOptionFancy("--coverprofile")
+    getopt.StringOptionFancy("--coverpkg")
+    getopt.StringOptionFancy("--since")
+    getopt.StringOptionFancy("--changed")
+    getopt.StringOptionFancy("--test-report")
+    getopt.StringOptionFancy("--importers")
+    getopt.StringOptionFancy("-Q --query query")
+    getopt.StringOptionFancy("--query-format")
     getopt.StringOptionFancy("-m --match")
     getopt.StringOptionFancy("--test-bin")
     getopt.StringOptionFancy("-B --backend")
+    getopt.StringOptionFancy("-j --jobs")
+    getopt.StringOptionFancy("--tags")
+    getopt.StringOptionFancy("--goos")
+    getopt.StringOptionFancy("--goarch")
 
     // new test options and aliases
     getopt.BoolOption("-test.short --test.short")
@@ -171,20 +220,44 @@ func init() {
         global.SetString("-test-bin", "gdtest")
     }
 
-    global.SetString("-backend", runtime.Compiler)
+    // 'go tool compile/link' unless we are built by gccgo
+    if runtime.Compiler == "gccgo" {
+        global.SetString("-backend", "gccgo")
+    } else {
+        global.SetString("-backend", "go")
+    }
+    global.SetInt("-jobs", runtime.NumCPU())
     global.SetString("-I", "")
 
 }
 
 // utility func for walker: *.go unless start = '_' || end = _test.go
+// or build constraints (filename/comments) rule the file out
 func noTestFilesFilter(s string) bool {
     return strings.HasSuffix(s, ".go") &&
         !strings.HasSuffix(s, "_test.go") &&
-        !strings.HasPrefix(filepath.Base(s), "_")
+        !strings.HasPrefix(filepath.Base(s), "_") &&
+        tags.Match(s)
 }
 
-// utility func for walker: *.go unless start = '_'
+// utility func for walker: *.go unless start = '_' or constraints..
+// vendored packages are never tested
 func allGoFilesFilter(s string) bool {
+    if strings.HasSuffix(s, "_test.go") && isVendored(s) {
+        return false
+    }
+    return strings.HasSuffix(s, ".go") &&
+        !strings.HasPrefix(filepath.Base(s), "_") &&
+        tags.Match(s)
+}
+
+func isVendored(s string) bool {
+    vendor := filepath.Join(filepath.Clean(srcdir), "vendor") + string(filepath.Separator)
+    return strings.HasPrefix(s, vendor)
+}
+
+// gofmt should see every file, not just the ones we build
+func fmtFilesFilter(s string) bool {
     return strings.HasSuffix(s, ".go") &&
         !strings.HasPrefix(filepath.Base(s), "_")
 }
@@ -230,12 +303,16 @@ func main() {
         say.Mute() // be silent unless error here
         single, name := dag.ParseSingle(os.Args[1])
         compiler.InitBackend()
-        compiler.CreateArgv(single)
+        if e := compiler.CreateArgv(single); e != nil {
+            log.Fatalf("[ERROR] %s\n", e)
+        }
         up2date = compiler.Compile(single)
         if handy.GOOS() == "windows" {
             name = name + ".exe"
         }
-        compiler.ForkLink(name, single, nil, up2date)
+        if !compiler.ForkLink(name, single, nil, up2date) {
+            os.Exit(1)
+        }
         args = os.Args[1:]
         args[0] = name
         handy.StdExecve(args, true)
@@ -279,6 +356,32 @@ func main() {
     // expand variables in -output
     global.SetString("-output", os.ExpandEnv(global.GetString("-output")))
 
+    // --coverprofile and --coverpkg imply --cover
+    if global.GetString("-coverprofile") != "" || global.GetString("-coverpkg") != "" {
+        global.SetBool("-cover", true)
+    }
+
+    // --test-report junit=path.xml or tap[=path]
+    compiler.CheckTestReport()
+
+    // --query-format list (default), dot or json
+    switch global.GetString("-query-format") {
+    case "", "list", "dot", "json":
+    default:
+        log.Fatalf("[ERROR] --query-format: '%s' is not list, dot or json\n",
+            global.GetString("-query-format"))
+    }
+
+    // max number of compile/link/gofmt jobs running in parallel
+    if global.GetString("-jobs") != "" {
+        jobs, e := strconv.Atoi(global.GetString("-jobs"))
+        if e != nil || jobs < 1 {
+            log.Fatalf("[ERROR] -jobs: '%s' is not a positive integer\n",
+                global.GetString("-jobs"))
+        }
+        global.SetInt("-jobs", jobs)
+    }
+
     if global.GetBool("-list") {
         printListing()
         os.Exit(0)
@@ -306,8 +409,104 @@ func main() {
         say.Mute()
     }
 
+    // structured events replace the chatter on stdout
+    if global.GetBool("-json") {
+        say.Mute()
+        event.Enable()
+    }
+
     handy.DirOrExit(srcdir)
-    files = walker.PathWalk(filepath.Clean(srcdir))
+
+    // --since/--changed: only test what is affected
+    var changed []string
+
+    if global.GetBool("-test") && testAffectedOnly() {
+        changed = changedFiles()
+    }
+
+    // --client: let the daemon do it, if there is one
+    if global.GetBool("-client") {
+        if req := clientRequest(changed); req != nil {
+            if status, served := client(req); served {
+                os.Exit(status)
+            }
+        }
+    }
+
+    // go.mod => module path prefix + required modules
+    if pathname := gomod.Find(srcdir); pathname != "" {
+        m, e := gomod.Parse(pathname)
+        if e != nil {
+            log.Fatalf("[ERROR] %s\n", e)
+        }
+        dag.SetModule(m)
+    }
+
+    // one build for each target (--goos/--goarch) or just the default
+    targets := buildTargets()
+
+    if global.GetBool("-watch") {
+        if len(targets) > 1 {
+            log.Fatalf("[ERROR] --watch: only one target (--goos/--goarch)\n")
+        }
+        handy.SetTarget(targets[0][0], targets[0][1])
+        watch(changed)
+    }
+
+    if global.GetBool("-serve") {
+        if len(targets) > 1 {
+            log.Fatalf("[ERROR] --serve: only one target (--goos/--goarch)\n")
+        }
+        handy.SetTarget(targets[0][0], targets[0][1])
+        serve()
+    }
+
+    for i := 0; i < len(targets); i++ {
+        handy.SetTarget(targets[i][0], targets[i][1])
+        if len(targets) > 1 {
+            say.Printf("target   : %s_%s\n", handy.GOOS(), handy.GOARCH())
+        }
+        if !build(len(targets) > 1, changed) {
+            os.Exit(1)
+        }
+    }
+
+    if global.GetBool("-clean") {
+        os.Exit(0)
+    }
+}
+
+// cross product of --goos and --goarch (comma separated lists), an
+// empty target means whatever $GOOS/$GOARCH or the host says
+func buildTargets() (targets [][2]string) {
+
+    split := func(s string) []string {
+        if s == "" {
+            return []string{""}
+        }
+        return strings.Split(s, ",")
+    }
+
+    oses := split(global.GetString("-goos"))
+    arches := split(global.GetString("-goarch"))
+
+    for i := 0; i < len(oses); i++ {
+        for j := 0; j < len(arches); j++ {
+            targets = append(targets, [2]string{oses[i], arches[j]})
+        }
+    }
+
+    return targets
+}
+
+// everything from gathering files to linking, for the current target,
+// multi == true => more targets, i.e. binaries in target directories,
+// only packages affected by changed files are tested (nil => all)
+func build(multi bool, changed []string) bool {
+
+    var up2date bool
+
+    files = gatherFiles()
 
     // gofmt on all files gathered
     if global.GetBool("-fmt") {
@@ -315,9 +514,7 @@ func main() {
         os.Exit(0)
     }
 
-    // parse the source code, look for dependencies
-    dgrph := dag.New()
-    dgrph.Parse(srcdir, files)
+    dgrph := parseFiles(files)
 
     // print collected dependency info
     if global.GetBool("-print") {
@@ -325,6 +522,18 @@ func main() {
         os.Exit(0)
     }
 
+    // print packages importing a package
+    if global.GetString("-importers") != "" {
+        printImporters(dgrph, global.GetString("-importers"))
+        os.Exit(0)
+    }
+
+    // packages matching a query expression
+    if global.GetString("-query") != "" {
+        printQuery(dgrph, global.GetString("-query"))
+        os.Exit(0)
+    }
+
     // draw graphviz dot graph
     if global.GetString("-dot") != "" {
         dgrph.MakeDotGraph(global.GetString("-dot"))
@@ -333,6 +542,9 @@ func main() {
 
     // build  all external dependencies
     if global.GetBool("-external") {
+       if global.GetBool("-vendor-only") {
+        log.Fatalf("[ERROR] -external and -vendor-only do not mix\n")
+       }
        // update external dependencies
        if global.GetBool("-update-external") {
         dgrph.External(true)
@@ -342,14 +554,20 @@ func main() {
         os.Exit(0)
     }
 
-    // sort graph based on dependencies
+    // sort graph based on dependencies, a loop is an edit in progress
+    // for --watch and --serve
     dgrph.GraphBuilder()
+
+    if !loopFree(dgrph) {
+        return false
+    }
+
     sorted := dgrph.Topsort()
 
     // clean only what we possibly could have generated…
     if global.GetBool("-clean") {
         compiler.DeleteObjects(srcdir, sorted)
-        os.Exit(0)
+        return true
     }
 
     // print packages sorted
@@ -360,12 +578,21 @@ func main() {
         os.Exit(0)
     }
 
+    // --since/--changed/--watch: test what a change could have broken
+    if global.GetBool("-test") && changed != nil {
+        dgrph.TestOnly(changed)
+    }
+
     // compile argv
     compiler.Init(srcdir, includes)
-    if global.GetString("-lib") != "" {
-        compiler.CreateLibArgv(sorted)
-    } else {
-        compiler.CreateArgv(sorted)
+
+    // --cover: compile instrumented source
+    if global.GetBool("-test") && global.GetBool("-cover") && !global.GetBool("-dryrun") {
+        compiler.Instrument(dgrph.CoverPackages())
+    }
+
+    if !createArgv(dgrph, sorted) {
+        return false
     }
 
     // gdmk
@@ -379,67 +606,315 @@ func main() {
         compiler.Dryrun(sorted)
     } else {
         up2date = compiler.Compile(sorted) // updated parallel
+        if global.GetBool("-keep-going") && !summary(sorted) {
+            return false
+        }
     }
 
     // test
-    if global.GetBool("-test") {
-        os.Setenv("SRCROOT", srcdir)
-        testMain, testDir, testLib := dgrph.MakeMainTest(srcdir)
-        if global.GetString("-lib") != "" {
-            compiler.CreateLibArgv(testMain)
-        } else {
-            compiler.CreateArgv(testMain)
+    if global.GetBool("-test") && !test(dgrph, sorted) {
+        return false
+    }
+
+    output := global.GetString("-output")
+    bindir := "bin"
+
+    // several targets: out/prog -> out/linux_arm/prog
+    if multi {
+        target := handy.GOOS() + "_" + handy.GOARCH()
+        bindir = filepath.Join(bindir, target)
+        if output != "" {
+            dir, base := filepath.Split(output)
+            handy.DirOrMkdir(filepath.Join(dir, target))
+            output = filepath.Join(dir, target, base)
+        }
+    }
+
+    // link if ! up2date
+    if output != "" {
+        return compiler.ForkLink(output, sorted, nil, up2date)
+    } else if global.GetBool("-all") {
+        return compiler.ForkLinkAll(bindir, sorted, up2date)
+    }
+
+    return true
+}
+
+// build constraints are evaluated as files are gathered
+func gatherFiles() []string {
+
+    tags.Init(handy.GOOS(), handy.GOARCH(),
+        global.GetString("-backend"), global.GetString("-tags"))
+
+    return walker.PathWalk(filepath.Clean(srcdir))
+}
+
+// parse the source code, look for dependencies
+func parseFiles(files []string) dag.Dag {
+
+    dgrph := dag.New()
+    dgrph.Parse(srcdir, files)
+
+    if global.GetBool("-vendor-only") {
+        dgrph.CheckVendored()
+    }
+
+    dgrph.ParseRequired()
+
+    return dgrph
+}
+
+// -keep-going: print what happened to each package, unless nobody
+// asked for -keep-going (--serve)
+func summary(sorted []*dag.Package) bool {
+    if keepGoingForced {
+        return compiler.Compiled(sorted)
+    }
+    return compiler.Summary(sorted)
+}
+
+// loops stop gd (Topsort), dgrph must be built (GraphBuilder)
+func loopFree(dgrph dag.Dag) bool {
+
+    if report := dgrph.LoopReport(); report != "" {
+        log.Printf("[ERROR] loop in dependency graph\n%s", report)
+        return false
+    }
+
+    return true
+}
+
+func printImporters(dgrph dag.Dag, name string) []string {
+
+    names := make([]string, 0)
+
+    for _, p := range dgrph.Importers(name) {
+        fmt.Printf("%s\n", p.Name)
+        names = append(names, p.Name)
+    }
+
+    return names
+}
+
+func printQuery(dgrph dag.Dag, expr string) {
+
+    parsed, e := query.Parse(expr)
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    names, e := dgrph.Query(parsed)
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    dgrph.PrintQuery(names, global.GetString("-query-format"))
+}
+
+func createArgv(dgrph dag.Dag, sorted []*dag.Package) bool {
+    return compileArgv(compiler.SeparateLib() || dgrph.HasForeign(), sorted)
+}
+
+// imports nobody can find are an edit in progress for --watch/--serve
+func compileArgv(lib bool, pkgs []*dag.Package) bool {
+
+    var e error
+
+    if lib {
+        e = compiler.CreateLibArgv(pkgs)
+    } else {
+        e = compiler.CreateArgv(pkgs)
+    }
+
+    if e != nil {
+        log.Printf("[ERROR] %s\n", e)
+        return false
+    }
+
+    return true
+}
+
+func testAffectedOnly() bool {
+    return global.GetString("-since") != "" || global.GetString("-changed") != ""
+}
+
+// files given by --changed, and/or those differing from --since in
+// the work tree (untracked files included), as absolute paths
+func changedFiles() []string {
+
+    changed := make([]string, 0) // nothing changed != test all
+
+    if global.GetString("-changed") != "" {
+        for _, f := range strings.Split(global.GetString("-changed"), ",") {
+            if f = strings.TrimSpace(f); f != "" {
+                changed = append(changed, f)
+            }
+        }
+    }
+
+    since := global.GetString("-since")
+
+    if since == "" {
+        return changed
+    }
+
+    git := func(argv ...string) []string {
+        stdout, stderr, _, e := handy.CaptureIn(srcdir, append([]string{"git"}, argv...))
+        if e != nil {
+            log.Fatalf("[ERROR] git %s: %s\n%s", strings.Join(argv, " "), e, stderr)
         }
-        if !global.GetBool("-dryrun") {
-            compiler.Compile(testMain)
+        lines := make([]string, 0)
+        for _, line := range strings.Split(stdout, "\n") {
+            if line != "" {
+                lines = append(lines, line)
+            }
         }
-        switch global.GetString("-backend") {
-        case "gc", "express":
-            compiler.ForkLink(global.GetString("-test-bin"), testMain, nil, false)
-        case "gccgo", "gcc":
-            compiler.ForkLink(global.GetString("-test-bin"), testMain, sorted, false)
-        default:
-            log.Fatalf("[ERROR] '%s' unknown back-end\n", global.GetString("-backend"))
+        return lines
+    }
+
+    top := git("rev-parse", "--show-toplevel")
+    if len(top) != 1 {
+        log.Fatalf("[ERROR] --since: cannot find root of git repository\n")
+    }
+
+    files := git("diff", "--name-only", since, "--")
+    files = append(files, git("ls-files", "--others", "--exclude-standard", "--full-name")...)
+
+    for _, f := range files {
+        changed = append(changed, filepath.Join(top[0], f))
+    }
+
+    return changed
+}
+
+// compile and run tests, false if any of them failed
+func test(dgrph dag.Dag, sorted []*dag.Package) bool {
+
+    ok := true
+
+    if handy.CrossCompiling() && !global.GetBool("-dryrun") {
+        log.Fatalf("[ERROR] cannot run tests for %s_%s on this machine\n",
+            handy.GOOS(), handy.GOARCH())
+    }
+
+    // absolute, tests may run from their package directory
+    if abs, e := filepath.Abs(srcdir); e == nil {
+        os.Setenv("SRCROOT", abs)
+    } else {
+        os.Setenv("SRCROOT", srcdir)
+    }
+
+    libroot := ""
+    if compiler.SeparateLib() {
+        libroot = compiler.LibRoot()
+    }
+
+    if dgrph.NothingToTest() {
+        say.Printf("testing  : no package affected by change\n")
+    } else if global.GetBool("-per-package") {
+        ok = testPerPackage(dgrph, sorted, srcdir, libroot)
+    } else {
+        testMain, testDir, testLib := dgrph.MakeMainTest(srcdir, libroot)
+        linked := compileArgv(compiler.SeparateLib(), testMain)
+        if linked {
+            if !global.GetBool("-dryrun") {
+                compiler.Compile(testMain)
+            }
+            switch global.GetString("-backend") {
+            case "go", "gc", "express":
+                linked = compiler.ForkLink(global.GetString("-test-bin"), testMain, nil, false)
+            case "gccgo", "gcc":
+                linked = compiler.ForkLink(global.GetString("-test-bin"), testMain, sorted, false)
+            default:
+                log.Fatalf("[ERROR] '%s' unknown back-end\n", global.GetString("-backend"))
+            }
+            compiler.DeletePackages(testMain)
         }
-        compiler.DeletePackages(testMain)
         handy.Delete(testDir, false)
         if testLib != "" {
             handy.Delete(testLib, false)
         }
         testArgv := compiler.CreateTestArgv()
-        if global.GetBool("-dryrun") {
-            testArgv[0] = filepath.Base(testArgv[0])
-            say.Printf("%s\n", strings.Join(testArgv, " "))
+        if !linked {
+            ok = false
+        } else if global.GetBool("-dryrun") {
+            line := append([]string{filepath.Base(testArgv[0])}, testArgv[1:]...)
+            event.Dryrun(&event.Event{Output: global.GetString("-test-bin"), Argv: testArgv},
+                fmt.Sprintf("%s\n", strings.Join(line, " ")))
         } else {
             say.Printf("testing  : ")
             if global.GetBool("-verbose") || global.GetBool("-test.v") {
                 say.Printf("\n")
             }
-            ok = handy.StdExecve(testArgv, false)
-            handy.Delete(global.GetString("-test-bin"), false)
-            if !ok {
-                os.Exit(1)
+            if compiler.Reporting() {
+                ok = compiler.RunTestReport(testArgv, dgrph.TestSuites())
+            } else if event.Enabled() {
+                ok = event.Run(&event.Event{Action: "test",
+                    Output: global.GetString("-test-bin"), Argv: testArgv})
+            } else {
+                ok = handy.StdExecve(testArgv, false)
             }
+            handy.Delete(global.GetString("-test-bin"), false)
         }
+    }
+
+    recompile := false
 
-        // if packages contain both test-files and regular files
-        // test-files should not be part of the objects, i.e. init
-        // functions in test-packages can cause unexpected behaviour
+    // objects should not keep coverage counters
+    if compiler.Uninstrument() {
+        say.Printf("recompile: --cover\n")
+        ok = createArgv(dgrph, sorted) && ok
+        recompile = true
+    }
 
-        if compiler.ReCompile(sorted) {
-            say.Printf("recompile: --tests\n")
-            compiler.Compile(sorted)
+    // if packages contain both test-files and regular files
+    // test-files should not be part of the objects, i.e. init
+    // functions in test-packages can cause unexpected behaviour
+
+    if compiler.ReCompile(sorted) {
+        say.Printf("recompile: --tests\n")
+        recompile = true
+    }
+
+    if recompile {
+        compiler.Compile(sorted)
+    }
+
+    return ok
+}
+
+// -per-package: one test binary per package, like 'go test ./...'
+func testPerPackage(dgrph dag.Dag, sorted []*dag.Package, srcdir, libroot string) bool {
+
+    mains, tested, testDir, testLib := dgrph.MakeMainTests(srcdir, libroot)
+
+    if !compileArgv(compiler.SeparateLib(), mains) {
+        handy.RmRf(testDir, false)
+        if testLib != "" {
+            handy.RmRf(testLib, false)
         }
+        return false
+    }
 
+    if !global.GetBool("-dryrun") {
+        compiler.Compile(mains)
     }
 
-    // link if ! up2date
-    if global.GetString("-output") != "" {
-        compiler.ForkLink(global.GetString("-output"), sorted, nil, up2date)
-    } else if global.GetBool("-all") {
-        compiler.ForkLinkAll(sorted, up2date)
+    binaries, ok := compiler.ForkLinkTests(mains, sorted)
+    compiler.DeletePackages(mains)
+
+    if ok {
+        ok = compiler.RunTests(tested, binaries)
+    }
+
+    handy.RmRf(testDir, false)
+    if testLib != "" {
+        handy.RmRf(testLib, false)
     }
 
+    return ok
 }
 
 func parseArgv(argv []string) (args []string) {
@@ -458,11 +933,15 @@ func parseArgv(argv []string) (args []string) {
         }
     }
 
-    if getopt.IsSet("-test") || getopt.IsSet("-fmt") || getopt.IsSet("-clean") {
+    if getopt.IsSet("-test") || getopt.IsSet("-clean") {
         // override IncludeFile to make walker pick _test.go files
         walker.IncludeFile = allGoFilesFilter
     }
 
+    if getopt.IsSet("-fmt") {
+        walker.IncludeFile = fmtFilesFilter
+    }
+
     if getopt.IsSet("-gdmk") {
         global.SetString("-lib", "_obj")
         // gdmk does not support testing
@@ -499,26 +978,48 @@ func printHelp() {
   -y --strip           strip symbols from executable
   -g --gdmk            create a go makefile for project
   -d --dryrun          print what gd would do (stdout)
+  -k --keep-going      continue with packages not depending on failures
+  -W --watch           rebuild (and test) whenever a source file changes
+  --serve              keep source parsed, serve --client on a socket
+  --client             let a --serve daemon do it (if there is one)
+  --json               print build events as JSON (one per line)
   -c --clean           delete generated object code
   -q --quiet           silent, print only errors
   -L --lib             write objects to other dir (!src)
   -M --main            regex to select main package
   -a --all             link main pkgs to bin/nameOfMainDir
   -D --dot             create a graphviz dot file
+  --importers          print packages importing a package
+  -Q --query           print packages matching expression, deps(a) - b ..
+  --query-format       print query result as: list (default), dot, json
   -I                   import package directories
   -t --test            run all unit-tests
   -m --match           regex to select unit-tests
   -b --bench           regex to select benchmarks
+  --fuzz               regex to select fuzz target to run (with -t)
+  --fuzztime           how long to fuzz, 30s or 1000x (default: forever)
+  --cover              print test coverage of packages under test
+  --coverprofile       write coverage profile to file (implies --cover)
+  --coverpkg           regex to select packages to cover (implies --cover)
   -V --verbose         verbose unit-test and go install
   --test-bin           name of test-binary (default: gdtest)
+  --per-package        one test binary per package (with -t)
+  --since              test packages changed since git ref (+dependents)
+  --changed            test packages owning these files (comma separated)
+  --test-report        write test report: junit=path.xml, tap[=path]
   --test.*             any valid gotest option
   -f --fmt             run gofmt on src and exit
   -r --rewrite         pass rewrite rule to gofmt
   -T --tab             pass -tabs=true to gofmt
   -w --tabwidth        pass -tabwidth to gofmt (default: 4)
   -e --external        go install all external dependencies
+  --vendor-only        only vendor/ and stdlib, no $GOPATH or network
   -u --updatex         go install -u all external dependencies
-  -B --backend         [gc,gccgo,express] (default: gc)
+  -B --backend         [go,gc,gccgo,express] (default: go)
+  -j --jobs            max parallel jobs (default: #cpus)
+  --tags               build tags to satisfy (comma separated)
+  --goos               target operating system(s) (comma separated)
+  --goarch             target architecture(s) (comma separated)
     `
 
     fmt.Println(helpMSG)
@@ -0,0 +1,559 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+    "bufio"
+    "cmplr/dag"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+    "syscall"
+    "utilz/global"
+    "utilz/handy"
+    "utilz/say"
+    "utilz/walker"
+)
+
+// --serve: keep parsed source around and answer requests over a unix
+// domain socket, --client: send a request instead of doing the work,
+// or do the work if nobody is serving the source directory.
+//
+// The client writes one request, the daemon answers with any number
+// of output lines, and a last line with the exit status:
+//
+//  -> {"op": "test", "changed": ["src/a/a.go"], "options": {...}}
+//  <- {"stdout": "compiling: a\n"}
+//  <- {"stderr": "..."}
+//  <- {"exit": 0, "packages": [...]}
+//
+// Ops:
+//
+//  build      compile (and link) like gd would
+//  test       build and test, only what changed affects if changed != null
+//  sort       packages in the order they are compiled
+//  print      packages, files and dependencies
+//  dot        graphviz dot graph written to file
+//  importers  packages importing package
+//
+// Requests are handled one at a time, with the options the daemon was
+// started with; a client with other options is refused, {"refused":
+// "..."}, and does the work itself. Errors gd cannot recover from stop
+// the daemon, clients will then do the work themselves.
+
+type request struct {
+    Op      string            `json:"op"`
+    Package string            `json:"package,omitempty"` // importers
+    File    string            `json:"file,omitempty"`    // dot
+    Changed []string          `json:"changed"`           // test, null => all
+    Options map[string]string `json:"options"`           // as the daemon
+}
+
+type reply struct {
+    Stdout   string   `json:"stdout,omitempty"`
+    Stderr   string   `json:"stderr,omitempty"`
+    Exit     *int     `json:"exit,omitempty"`
+    Packages []string `json:"packages,omitempty"` // sort, importers
+    Refused  string   `json:"refused,omitempty"`  // options differ
+}
+
+// files seen by the previous request, syntax checked
+var servedFiles map[string]stamp
+
+// the socket we serve, removed on exit
+var served string
+
+// options the daemon was started with
+var servedOptions map[string]string
+
+// -keep-going is ours, not asked for => no summary of each build
+var keepGoingForced bool
+
+// options deciding what is done, not what the request is (-test etc.)
+var requestOptions = map[string]bool{
+    "-serve": true, "-client": true, "-test": true, "-sort": true,
+    "-print": true, "-dot": true, "-importers": true, "-since": true,
+    "-changed": true,
+}
+
+// one socket per source directory
+func socketPath() string {
+
+    abs, e := filepath.Abs(srcdir)
+
+    if e != nil {
+        log.Fatalf("[ERROR] %s\n", e)
+    }
+
+    return filepath.Join(socketDir(), "gd-"+handy.Sha1(abs)[:16]+".sock")
+}
+
+// $XDG_RUNTIME_DIR/godag, or godag/run in the user cache directory,
+// nobody else should be able to enter it (0700, see privateDir)
+func socketDir() string {
+
+    if run := os.Getenv("XDG_RUNTIME_DIR"); run != "" {
+        return filepath.Join(run, "godag")
+    }
+
+    if cache, e := os.UserCacheDir(); e == nil {
+        return filepath.Join(cache, "godag", "run")
+    }
+
+    return filepath.Join(os.TempDir(), fmt.Sprintf("godag-%d", os.Getuid()))
+}
+
+// a directory (not a link) of our own, only we can enter it
+func privateDir(dir string) error {
+
+    if e := os.MkdirAll(dir, 0700); e != nil {
+        return e
+    }
+
+    // fails if somebody else made it
+    if e := os.Chmod(dir, 0700); e != nil {
+        return e
+    }
+
+    fi, e := os.Lstat(dir)
+
+    if e != nil {
+        return e
+    }
+
+    if !fi.IsDir() || fi.Mode().Perm() != 0700 {
+        return fmt.Errorf("%s: not a private directory", dir)
+    }
+
+    return nil
+}
+
+// never returns, stop with Ctrl-C
+func serve() {
+
+    path := socketPath()
+
+    if e := privateDir(filepath.Dir(path)); e != nil {
+        log.Fatalf("[ERROR] --serve: %s\n", e)
+    }
+
+    if conn, e := net.Dial("unix", path); e == nil {
+        conn.Close()
+        log.Fatalf("[ERROR] --serve: %s is served already: %s\n", srcdir, path)
+    }
+
+    os.Remove(path) // left behind by a daemon that died
+
+    listener, e := net.Listen("unix", path)
+
+    if e != nil {
+        log.Fatalf("[ERROR] --serve: %s\n", e)
+    }
+
+    served = path
+    logTo(os.Stderr)
+
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+    go func() {
+        <-stop
+        os.Remove(path)
+        os.Exit(0)
+    }()
+
+    servedOptions = optionSet()
+
+    // a compile error should not stop the daemon
+    keepGoingForced = !global.GetBool("-keep-going")
+    global.SetBool("-keep-going", true)
+
+    dag.KeepParsed()
+    servedFiles = make(map[string]stamp)
+
+    say.Printf("serving  : %s on %s\n", srcdir, path)
+
+    for {
+        conn, e := listener.Accept()
+        if e != nil {
+            log.Printf("[WARNING] --serve: %s\n", e)
+            continue
+        }
+        handle(conn)
+    }
+}
+
+func handle(conn net.Conn) {
+
+    defer conn.Close()
+
+    req := new(request)
+    out := &replyWriter{enc: json.NewEncoder(conn)}
+
+    if e := json.NewDecoder(conn).Decode(req); e != nil {
+        out.send(&reply{Stderr: fmt.Sprintf("[ERROR] bad request: %s\n", e)})
+        out.exit(1, nil)
+        return
+    }
+
+    if diff := optionDiff(req.Options, servedOptions); diff != "" {
+        say.Printf("refused  : %s (%s)\n", req.Op, diff)
+        out.send(&reply{Refused: diff})
+        return
+    }
+
+    say.Printf("request  : %s\n", req.Op)
+
+    var ok bool
+    var pkgs []string
+
+    out.capture(func() {
+        ok, pkgs = serveRequest(req)
+    })
+
+    if ok {
+        out.exit(0, pkgs)
+    } else {
+        out.exit(1, pkgs)
+    }
+}
+
+func serveRequest(req *request) (bool, []string) {
+
+    switch req.Op {
+    case "build", "test":
+        test := req.Op == "test"
+        defer testFiles(global.GetBool("-test"))
+        testFiles(test)
+        if !syntaxOk() {
+            return false, nil
+        }
+        if test {
+            return build(false, req.Changed), nil
+        }
+        return build(false, nil), nil
+    case "sort", "print", "dot", "importers":
+        if !syntaxOk() {
+            return false, nil
+        }
+        return serveQuery(req)
+    }
+
+    log.Printf("[ERROR] unknown op: '%s'\n", req.Op)
+
+    return false, nil
+}
+
+func serveQuery(req *request) (bool, []string) {
+
+    dgrph := parseFiles(gatherFiles())
+
+    switch req.Op {
+    case "print":
+        dgrph.PrintInfo()
+    case "dot":
+        if req.File == "" {
+            log.Printf("[ERROR] dot: missing file\n")
+            return false, nil
+        }
+        dgrph.MakeDotGraph(req.File)
+    case "importers":
+        return true, printImporters(dgrph, req.Package)
+    case "sort":
+        dgrph.GraphBuilder()
+        if !loopFree(dgrph) {
+            return false, nil
+        }
+        names := make([]string, 0)
+        for _, p := range dgrph.Topsort() {
+            fmt.Printf("%s\n", p.Name)
+            names = append(names, p.Name)
+        }
+        return true, names
+    }
+
+    return true, nil
+}
+
+// the walker picks up _test.go files for -test
+func testFiles(on bool) {
+    global.SetBool("-test", on)
+    if on {
+        walker.IncludeFile = allGoFilesFilter
+    } else {
+        walker.IncludeFile = noTestFilesFilter
+    }
+}
+
+// parse errors stop gd, check files changed since last time first
+func syntaxOk() bool {
+
+    changed := make([]string, 0)
+    now := snapshot()
+
+    for f, s := range now {
+        if old, ok := servedFiles[f]; !ok || old.size != s.size || !old.mtime.Equal(s.mtime) {
+            changed = append(changed, f)
+        }
+    }
+
+    if !parses(changed) {
+        return false
+    }
+
+    for f, s := range now {
+        servedFiles[f] = s
+    }
+
+    return true
+}
+
+// log.Fatal* ends the daemon, the socket should go with it
+type fatalGuard struct {
+    out io.Writer
+}
+
+func logTo(out io.Writer) {
+    log.SetOutput(&fatalGuard{out})
+}
+
+func (g *fatalGuard) Write(p []byte) (int, error) {
+    if calledByFatal() {
+        os.Remove(served)
+    }
+    return g.out.Write(p)
+}
+
+func calledByFatal() bool {
+
+    pc := make([]uintptr, 32)
+    frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+
+    for {
+        frame, more := frames.Next()
+        switch frame.Function {
+        case "log.Fatal", "log.Fatalf", "log.Fatalln",
+            "log.(*Logger).Fatal", "log.(*Logger).Fatalf", "log.(*Logger).Fatalln":
+            return true
+        }
+        if !more {
+            return false
+        }
+    }
+}
+
+type replyWriter struct {
+    enc  *json.Encoder
+    lock sync.Mutex
+}
+
+func (w *replyWriter) send(r *reply) {
+    w.lock.Lock()
+    w.enc.Encode(r) // client gone => nothing to do about it
+    w.lock.Unlock()
+}
+
+func (w *replyWriter) exit(status int, pkgs []string) {
+    w.send(&reply{Exit: &status, Packages: pkgs})
+}
+
+// run f with stdout, stderr and the log (compilers and tests included)
+// sent to the client
+func (w *replyWriter) capture(f func()) {
+
+    stdoutR, stdoutW, e1 := os.Pipe()
+    stderrR, stderrW, e2 := os.Pipe()
+
+    if e1 != nil || e2 != nil {
+        log.Printf("[ERROR] --serve: could not capture output\n")
+        f()
+        return
+    }
+
+    stdout, stderr := os.Stdout, os.Stderr
+
+    os.Stdout, os.Stderr = stdoutW, stderrW
+    logTo(stderrW)
+
+    wg := new(sync.WaitGroup)
+    wg.Add(2)
+
+    go w.forward(stdoutR, false, wg)
+    go w.forward(stderrR, true, wg)
+
+    f()
+
+    os.Stdout, os.Stderr = stdout, stderr
+    logTo(stderr)
+
+    stdoutW.Close()
+    stderrW.Close()
+    wg.Wait()
+}
+
+func (w *replyWriter) forward(r *os.File, stderr bool, wg *sync.WaitGroup) {
+
+    reader := bufio.NewReader(r)
+
+    for {
+        line, e := reader.ReadString('\n')
+        if line != "" {
+            if stderr {
+                w.send(&reply{Stderr: line})
+            } else {
+                w.send(&reply{Stdout: line})
+            }
+        }
+        if e != nil {
+            break
+        }
+    }
+
+    r.Close()
+    wg.Done()
+}
+
+// false => nobody is serving, do the work yourself
+func client(req *request) (status int, served bool) {
+
+    conn, e := net.Dial("unix", socketPath())
+
+    if e != nil {
+        return 0, false
+    }
+
+    defer conn.Close()
+
+    req.Options = optionSet()
+
+    if e = json.NewEncoder(conn).Encode(req); e != nil {
+        return 0, false
+    }
+
+    dec := json.NewDecoder(conn)
+
+    for {
+        r := new(reply)
+        if e = dec.Decode(r); e != nil {
+            if e != io.EOF {
+                log.Printf("[WARNING] --client: %s\n", e)
+            }
+            log.Printf("[WARNING] --client: daemon went away, doing it myself\n")
+            return 0, false
+        }
+        if r.Stdout != "" {
+            fmt.Fprint(os.Stdout, r.Stdout)
+        }
+        if r.Stderr != "" {
+            fmt.Fprint(os.Stderr, r.Stderr)
+        }
+        if r.Refused != "" {
+            log.Printf("[WARNING] --client: daemon has other options (%s), doing it myself\n",
+                r.Refused)
+            return 0, false
+        }
+        if r.Exit != nil {
+            return *r.Exit, true
+        }
+    }
+}
+
+// bool and string options (-I included) as given, minus request options
+func optionSet() map[string]string {
+
+    options := make(map[string]string)
+
+    for _, bkey := range bools {
+        if !requestOptions[bkey] {
+            options[bkey] = fmt.Sprint(global.GetBool(bkey))
+        }
+    }
+
+    for _, skey := range strs {
+        if !requestOptions[skey] {
+            options[skey] = global.GetString(skey)
+        }
+    }
+
+    options["-I"] = strings.Join(includes, string(filepath.ListSeparator))
+
+    return options
+}
+
+// "" => same options, else what differs: -match=x (daemon: ), ..
+func optionDiff(client, daemon map[string]string) string {
+
+    keys := make([]string, 0)
+
+    for k := range daemon {
+        if v, ok := client[k]; !ok || v != daemon[k] {
+            keys = append(keys, k)
+        }
+    }
+
+    for k := range client {
+        if _, ok := daemon[k]; !ok {
+            keys = append(keys, k)
+        }
+    }
+
+    sort.Strings(keys)
+
+    diff := make([]string, len(keys))
+
+    for i := 0; i < len(keys); i++ {
+        diff[i] = fmt.Sprintf("%s=%s (daemon: %s)", keys[i], client[keys[i]], daemon[keys[i]])
+    }
+
+    return strings.Join(diff, ", ")
+}
+
+// what gd would have done, as a request; nil if the daemon cannot
+// do it (-clean, -fmt, -external, -gdmk, -dryrun, -query)
+func clientRequest(changed []string) *request {
+
+    switch {
+    case global.GetBool("-clean"), global.GetBool("-fmt"),
+        global.GetBool("-external"), global.GetString("-gdmk") != "",
+        global.GetBool("-dryrun"), global.GetString("-query") != "":
+        return nil
+    case global.GetBool("-sort"):
+        return &request{Op: "sort"}
+    case global.GetBool("-print"):
+        return &request{Op: "print"}
+    case global.GetString("-importers") != "":
+        return &request{Op: "importers", Package: global.GetString("-importers")}
+    case global.GetString("-dot") != "":
+        dot, e := filepath.Abs(global.GetString("-dot"))
+        if e != nil {
+            log.Fatalf("[ERROR] %s\n", e)
+        }
+        return &request{Op: "dot", File: dot}
+    case global.GetBool("-test"):
+        return &request{Op: "test", Changed: changed}
+    }
+
+    return &request{Op: "build"}
+}
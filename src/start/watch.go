@@ -0,0 +1,189 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+    "cmplr/dag"
+    "fmt"
+    "go/parser"
+    "go/token"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+    "utilz/global"
+    "utilz/handy"
+    "utilz/say"
+    "utilz/timer"
+    "utilz/walker"
+)
+
+// --watch: build, then poll the go files gathered by the walker and
+// build again when some are added, removed or modified. a burst of
+// saves (editor, git checkout) gives one build, files are parsed
+// again only if they changed, and with -test only packages affected
+// by the changes are tested. The graph is not updated in place, each
+// build makes a new one from the parsed files, which is cheap next
+// to compiling; an edit in progress (syntax error, import loop or
+// an import nobody can find) fails the build, not the watch.
+
+const (
+    pollInterval = 500 * time.Millisecond
+    quietPeriod  = 300 * time.Millisecond // no changes => build
+)
+
+type stamp struct {
+    size  int64
+    mtime time.Time
+}
+
+// never returns, stop with Ctrl-C
+func watch(changed []string) {
+
+    // a compile error should not end the watch
+    global.SetBool("-keep-going", true)
+
+    dag.KeepParsed()
+
+    for {
+
+        timer.Start("watch")
+        ok := build(false, changed)
+        timer.Stop("watch")
+        delta, _ := timer.Delta("watch")
+
+        if ok {
+            say.Printf("watching : ok (%s)\n", timer.Nano2Time(delta))
+        } else {
+            fmt.Fprintf(os.Stderr, "watching : FAIL (%s)\n", timer.Nano2Time(delta))
+        }
+
+        // gd writes nothing while we wait, i.e. only new changes count
+        before := snapshot()
+        changed = make([]string, 0)
+
+        for {
+            var more []string
+            more, before = waitForChanges(before)
+            changed = union(changed, more)
+            for i := 0; i < len(more); i++ {
+                say.Printf("changed  : %s\n", more[i])
+            }
+            // a half written file would stop gd, wait for the rest
+            if parses(changed) {
+                break
+            }
+        }
+    }
+}
+
+func snapshot() map[string]stamp {
+
+    stamps := make(map[string]stamp)
+
+    for _, f := range walker.PathWalk(filepath.Clean(srcdir)) {
+        if fi, e := os.Stat(f); e == nil {
+            stamps[f] = stamp{fi.Size(), fi.ModTime()}
+        }
+    }
+
+    return stamps
+}
+
+// block until something changed, and nothing has changed for a while
+func waitForChanges(before map[string]stamp) ([]string, map[string]stamp) {
+
+    for {
+
+        time.Sleep(pollInterval)
+
+        now := snapshot()
+        changed := compare(before, now)
+
+        if len(changed) == 0 {
+            continue
+        }
+
+        for {
+            time.Sleep(quietPeriod)
+            later := snapshot()
+            more := compare(now, later)
+            if len(more) == 0 {
+                return changed, now
+            }
+            changed = union(changed, more)
+            now = later
+        }
+    }
+}
+
+// added, removed or modified files, sorted
+func compare(before, now map[string]stamp) []string {
+
+    changed := make([]string, 0)
+
+    for f, s := range now {
+        if old, ok := before[f]; !ok || old.size != s.size || !old.mtime.Equal(s.mtime) {
+            changed = append(changed, f)
+        }
+    }
+
+    for f := range before {
+        if _, ok := now[f]; !ok {
+            changed = append(changed, f)
+        }
+    }
+
+    sort.Strings(changed)
+
+    return changed
+}
+
+func union(a, b []string) []string {
+
+    seen := make(map[string]bool)
+    all := make([]string, 0, len(a)+len(b))
+
+    for _, f := range append(a, b...) {
+        if !seen[f] {
+            seen[f] = true
+            all = append(all, f)
+        }
+    }
+
+    sort.Strings(all)
+
+    return all
+}
+
+// syntax errors are printed, removed files are fine
+func parses(files []string) bool {
+
+    ok := true
+
+    for _, f := range files {
+        if !strings.HasSuffix(f, ".go") || !handy.IsFile(f) {
+            continue
+        }
+        if _, e := parser.ParseFile(token.NewFileSet(), f, nil, 0); e != nil {
+            fmt.Fprintf(os.Stderr, "%s\n", e)
+            ok = false
+        }
+    }
+
+    return ok
+}
@@ -0,0 +1,140 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package event
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "sync"
+    "time"
+    "utilz/handy"
+)
+
+// Machine readable build events (--json); one JSON object per line
+// written to stdout, so builds can be fed into other tools. All
+// functions are no-ops unless Enable has been called.
+//
+// Actions:
+//
+//  start    package compile started (argv)
+//  step     cgo/pack step of a package compile (argv, elapsed, exit, stderr)
+//  finish   package compile done (argv, elapsed, exit, stderr)
+//  up2date  package or binary needs no work
+//  skip     package not compiled, a dependency failed
+//  link     binary linked (argv, elapsed, exit, stderr)
+//  rm       object file removed
+//  test     test binary run (argv, elapsed, exit, stdout, stderr)
+//  dryrun   command --dryrun would have run (argv, dir)
+
+type Event struct {
+    Time    time.Time `json:"time"`
+    Action  string    `json:"action"`
+    Package string    `json:"package,omitempty"`
+    Output  string    `json:"output,omitempty"`
+    Argv    []string  `json:"argv,omitempty"`
+    Dir     string    `json:"dir,omitempty"` // dryrun: run in dir
+    Elapsed float64   `json:"elapsed,omitempty"` // seconds
+    Exit    *int      `json:"exit,omitempty"`
+    Result  string    `json:"result,omitempty"` // ok, fail
+    Stdout  string    `json:"stdout,omitempty"`
+    Stderr  string    `json:"stderr,omitempty"`
+}
+
+// reassign to send events somewhere else
+var Out io.Writer = os.Stdout
+
+var enabled bool // false
+var lock = new(sync.Mutex)
+
+func Enable() {
+    enabled = true
+}
+
+func Enabled() bool {
+    return enabled
+}
+
+func Emit(e *Event) {
+
+    if !enabled {
+        return
+    }
+
+    if e.Time.IsZero() {
+        e.Time = time.Now()
+    }
+
+    b, err := json.Marshal(e)
+
+    if err != nil {
+        log.Printf("[ERROR] %s\n", err)
+        return
+    }
+
+    lock.Lock()
+    fmt.Fprintf(Out, "%s\n", b)
+    lock.Unlock()
+}
+
+// --dryrun: line (shell) is printed, or e is emitted with --json, the
+// events and the commands should not share stdout
+func Dryrun(e *Event, line string) {
+
+    if !enabled {
+        fmt.Print(line)
+        return
+    }
+
+    e.Action = "dryrun"
+    Emit(e)
+}
+
+// run e.Argv with output captured, fill in the result and emit e
+func Run(e *Event) bool {
+    return run(e, handy.Capture)
+}
+
+// Run for compilers/linkers, see handy.ToolCapture
+func RunTool(e *Event) bool {
+    return run(e, handy.ToolCapture)
+}
+
+func run(e *Event, capture func([]string) (string, string, int, error)) bool {
+
+    start := time.Now()
+    stdout, stderr, status, err := capture(e.Argv)
+
+    e.Elapsed = time.Since(start).Seconds()
+    e.Exit = &status
+    e.Stdout = stdout
+    e.Stderr = stderr
+
+    if err != nil {
+        e.Result = "fail"
+        if status == -1 {
+            e.Stderr += err.Error()
+        }
+    } else {
+        e.Result = "ok"
+    }
+
+    Emit(e)
+
+    return err == nil
+}
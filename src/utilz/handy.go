@@ -16,7 +16,9 @@
 package handy
 
 import (
+    "bytes"
     "crypto/sha1"
+    "errors"
     "fmt"
     "io"
     "io/ioutil"
@@ -32,6 +34,16 @@ import (
 // some utility functions
 
 func StdExecve(argv []string, stopOnTrouble bool) bool {
+    return execve(argv, nil, stopOnTrouble)
+}
+
+// StdExecve for compilers/linkers, target in $GOOS/$GOARCH
+func ToolExecve(argv []string, stopOnTrouble bool) bool {
+    return execve(argv, TargetEnv(), stopOnTrouble)
+}
+
+// env == nil => our own environment
+func execve(argv, env []string, stopOnTrouble bool) bool {
 
     var err error
     var cmd *exec.Cmd
@@ -49,6 +61,7 @@ func StdExecve(argv []string, stopOnTrouble bool) bool {
     }
 
     // pass-through
+    cmd.Env = env
     cmd.Stdout = os.Stdout
     cmd.Stderr = os.Stderr
     cmd.Stdin = os.Stdin
@@ -78,6 +91,51 @@ func StdExecve(argv []string, stopOnTrouble bool) bool {
     return true
 }
 
+// Same as StdExecve, but stdout and stderr are collected instead of
+// passed through. Exit status is -1 if the command could not be run.
+func Capture(argv []string) (stdout, stderr string, status int, err error) {
+    return CaptureIn("", argv)
+}
+
+// Capture for compilers/linkers, target in $GOOS/$GOARCH
+func ToolCapture(argv []string) (stdout, stderr string, status int, err error) {
+    return capture("", TargetEnv(), argv)
+}
+
+// Capture with working directory dir, "" => current directory
+func CaptureIn(dir string, argv []string) (stdout, stderr string, status int, err error) {
+    return capture(dir, nil, argv)
+}
+
+func capture(dir string, env, argv []string) (stdout, stderr string, status int, err error) {
+
+    var outbuf, errbuf bytes.Buffer
+
+    if len(argv) == 0 {
+        return "", "", -1, errors.New("len(argv) == 0")
+    }
+
+    cmd := exec.Command(argv[0], argv[1:]...)
+
+    cmd.Dir = dir
+    cmd.Env = env
+    cmd.Stdout = &outbuf
+    cmd.Stderr = &errbuf
+    cmd.Stdin = os.Stdin
+
+    err = cmd.Run()
+
+    if err != nil {
+        status = -1
+        exitErr, ok := err.(*exec.ExitError)
+        if ok {
+            status = exitErr.ExitCode()
+        }
+    }
+
+    return outbuf.String(), errbuf.String(), status, err
+}
+
 // Config files can be as simple as writing command line arguments,
 // after all that's all they are anyway, options we give every time.
 // This function takes a pathname which possibly contains a config
@@ -211,7 +269,18 @@ func Touch(pathname string) error {
     return e
 }
 
+// explicit target (--goos/--goarch) overrides $GOOS/$GOARCH
+var targetOS, targetArch string
+
+func SetTarget(goos, goarch string) {
+    targetOS = goos
+    targetArch = goarch
+}
+
 func GOOS() string {
+    if targetOS != "" {
+        return targetOS
+    }
     goos := os.Getenv("GOOS")
     if goos == "" {
         goos = runtime.GOOS
@@ -220,6 +289,9 @@ func GOOS() string {
 }
 
 func GOARCH() string {
+    if targetArch != "" {
+        return targetArch
+    }
     goarch := os.Getenv("GOARCH")
     if goarch == "" {
         goarch = runtime.GOARCH
@@ -227,6 +299,27 @@ func GOARCH() string {
     return goarch
 }
 
+// our environment with $GOOS/$GOARCH set to the target, compilers and
+// linkers pick the target from there; we do not Setenv, test binaries
+// and other programs we run should see what we were given
+func TargetEnv() []string {
+
+    env := make([]string, 0)
+
+    for _, kv := range os.Environ() {
+        if !strings.HasPrefix(kv, "GOOS=") && !strings.HasPrefix(kv, "GOARCH=") {
+            env = append(env, kv)
+        }
+    }
+
+    return append(env, "GOOS="+GOOS(), "GOARCH="+GOARCH())
+}
+
+// target differs from the platform we run on
+func CrossCompiling() bool {
+    return GOOS() != runtime.GOOS || GOARCH() != runtime.GOARCH
+}
+
 func GOROOT() string {
     goroot := os.Getenv("GOROOT")
     if goroot == "" {
@@ -258,11 +351,14 @@ func GoPathImports(backend string) (paths []string) {
         if backend == "gc" {
             stub = GOOS() + "_" + GOARCH()
         }else{
-            stub = "gccgo"
+            stub = "gccgo_" + GOOS() + "_" + GOARCH()
         }// should do something for express later perhaps
 
         for _, gp := range gopath {
             paths = append(paths, filepath.Join(gp, "pkg", stub))
+            if backend != "gc" { // old layout
+                paths = append(paths, filepath.Join(gp, "pkg", "gccgo"))
+            }
         }
     }
 
@@ -281,3 +377,25 @@ func Sha1(s string) (hex string) {
     io.WriteString(h, s)
     return fmt.Sprintf("%x", h.Sum(nil))
 }
+
+// sha1 hex of file content
+func Sha1File(pathname string) (hex string, err error) {
+
+    fd, err := os.Open(pathname)
+
+    if err != nil {
+        return "", err
+    }
+
+    defer fd.Close()
+
+    h := sha1.New()
+
+    _, err = io.Copy(h, fd)
+
+    if err != nil {
+        return "", err
+    }
+
+    return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
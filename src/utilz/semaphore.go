@@ -0,0 +1,45 @@
+//  Copyright © 2013 bjarneh
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package semaphore
+
+// Counting semaphore on top of a buffered channel, used to put an
+// upper limit on the number of jobs (compilers, linkers, gofmt..)
+// running at the same time.
+
+type Semaphore chan bool
+
+func New(size int) Semaphore {
+    if size < 1 {
+        size = 1
+    }
+    return make(Semaphore, size)
+}
+
+func (s Semaphore) Acquire() {
+    s <- true
+}
+
+func (s Semaphore) Release() {
+    <-s
+}
+
+func (s Semaphore) Size() int {
+    return cap(s)
+}
+
+func (s Semaphore) InUse() int {
+    return len(s)
+}
@@ -16,10 +16,14 @@
 package utilz_test
 
 import (
+    "bytes"
+    "encoding/json"
     "os"
     "path/filepath"
     "strings"
     "testing"
+    "utilz/event"
+    "utilz/semaphore"
     "utilz/stringbuffer"
     "utilz/stringset"
     "utilz/timer"
@@ -113,13 +117,35 @@ func TestWalker(t *testing.T) {
 
     // this is a bit static, will cause problems if
     // stuff is added or removed == not ideal..
+    ss.Add(filepath.Join(srcroot, "cmplr", "affected.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "cgo.go"))
     ss.Add(filepath.Join(srcroot, "cmplr", "compiler.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "cover.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "cycle.go"))
     ss.Add(filepath.Join(srcroot, "cmplr", "dag.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "dag_test.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "gotool.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "imports.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "parsecache.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "query.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "query_test.go"))
     ss.Add(filepath.Join(srcroot, "cmplr", "gdmake.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "report.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "state.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "testmain.go"))
+    ss.Add(filepath.Join(srcroot, "cmplr", "testrun.go"))
+    ss.Add(filepath.Join(srcroot, "parse", "gomod.go"))
+    ss.Add(filepath.Join(srcroot, "parse", "gomod_test.go"))
     ss.Add(filepath.Join(srcroot, "parse", "gopt.go"))
     ss.Add(filepath.Join(srcroot, "parse", "gopt_test.go"))
     ss.Add(filepath.Join(srcroot, "parse", "option.go"))
+    ss.Add(filepath.Join(srcroot, "parse", "query.go"))
+    ss.Add(filepath.Join(srcroot, "parse", "query_test.go"))
+    ss.Add(filepath.Join(srcroot, "parse", "tags.go"))
+    ss.Add(filepath.Join(srcroot, "parse", "tags_test.go"))
     ss.Add(filepath.Join(srcroot, "start", "main.go"))
+    ss.Add(filepath.Join(srcroot, "start", "serve.go"))
+    ss.Add(filepath.Join(srcroot, "start", "watch.go"))
     ss.Add(filepath.Join(srcroot, "utilz", "handy.go"))
     ss.Add(filepath.Join(srcroot, "utilz", "stringbuffer.go"))
     ss.Add(filepath.Join(srcroot, "utilz", "stringset.go"))
@@ -128,6 +154,8 @@ func TestWalker(t *testing.T) {
     ss.Add(filepath.Join(srcroot, "utilz", "global.go"))
     ss.Add(filepath.Join(srcroot, "utilz", "timer.go"))
     ss.Add(filepath.Join(srcroot, "utilz", "say.go"))
+    ss.Add(filepath.Join(srcroot, "utilz", "event.go"))
+    ss.Add(filepath.Join(srcroot, "utilz", "semaphore.go"))
 
     files := walker.PathWalk(filepath.Clean(srcroot))
 
@@ -192,3 +220,59 @@ func TestTimer(t *testing.T) {
     }
 
 }
+
+func TestSemaphore(t *testing.T) {
+
+    sem := semaphore.New(0)
+
+    if sem.Size() != 1 {
+        t.Fatalf("semaphore.New(0).Size() != 1 (%d)\n", sem.Size())
+    }
+
+    sem = semaphore.New(2)
+
+    sem.Acquire()
+    sem.Acquire()
+
+    if sem.InUse() != 2 {
+        t.Fatalf("semaphore.InUse() != 2 (%d)\n", sem.InUse())
+    }
+
+    sem.Release()
+
+    if sem.InUse() != 1 {
+        t.Fatalf("semaphore.InUse() != 1 (%d)\n", sem.InUse())
+    }
+}
+
+func TestEvent(t *testing.T) {
+
+    buf := new(bytes.Buffer)
+    event.Out = buf
+
+    event.Emit(&event.Event{Action: "not enabled"})
+
+    if buf.Len() != 0 {
+        t.Fatalf("event.Emit() wrote while disabled\n")
+    }
+
+    event.Enable()
+    event.Emit(&event.Event{Action: "rm", Output: "a.6"})
+    event.Emit(&event.Event{Action: "up2date", Package: "a"})
+
+    lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+    if len(lines) != 2 {
+        t.Fatalf("len(events) != 2 (%d)\n", len(lines))
+    }
+
+    e := new(event.Event)
+
+    if err := json.Unmarshal([]byte(lines[0]), e); err != nil {
+        t.Fatalf("event not JSON: %s\n", err)
+    }
+
+    if e.Action != "rm" || e.Output != "a.6" || e.Time.IsZero() {
+        t.Fatalf("bad event: %s\n", lines[0])
+    }
+}
@@ -29,13 +29,13 @@ _gd(){
 
     local cur prev opts gd_long_opts gd_short_opts gd_short_explain gd_special
     # long options
-    gd_long_opts="--help --version --list --print --sort --output --static --gdmk --dryrun --clean --quiet --lib --main --dot --test --bench --match --verbose --fmt --rewrite --tab --tabwidth --external --update-external --backend --test-bin --test.short --test.v --test.bench --test.benchtime --test.cpu --test.cpuprofile --test.memprofile --test.memprofilerate --test.timeout --strip"
+    gd_long_opts="--help --version --list --print --sort --output --static --gdmk --dryrun --clean --quiet --lib --main --dot --test --bench --fuzz --fuzztime --cover --coverprofile --coverpkg --match --verbose --fmt --rewrite --tab --tabwidth --external --update-external --vendor-only --backend --test-bin --per-package --since --changed --test-report --test.short --test.v --test.bench --test.benchtime --test.cpu --test.cpuprofile --test.memprofile --test.memprofilerate --test.timeout --strip --jobs --keep-going --watch --serve --client --importers --query --query-format --json --tags --goos --goarch"
     # short options + explain
-    gd_short_explain="-h[--help] -v[--version] -l[--list] -p[--print] -s[--sort] -o[--output] -S[--static] -g[--gdmk] -d[--dryrun] -c[--clean] -q[--quiet] -L[--lib] -M[--main] -D[--dot] -I -t[--test] -b[--bench] -m[--match] -V[--verbose] -f[--fmt] -r[--rewrite] -T[--tab] -w[--tabwidth] -e[--external] -u[--update--external]  -B[--backend] -y[--strip]"
+    gd_short_explain="-h[--help] -v[--version] -l[--list] -p[--print] -s[--sort] -o[--output] -S[--static] -g[--gdmk] -d[--dryrun] -c[--clean] -q[--quiet] -L[--lib] -M[--main] -D[--dot] -I -t[--test] -b[--bench] -m[--match] -V[--verbose] -f[--fmt] -r[--rewrite] -T[--tab] -w[--tabwidth] -e[--external] -u[--update--external]  -B[--backend] -y[--strip] -j[--jobs] -k[--keep-going] -W[--watch] -Q[--query]"
     # short options
-    gd_short_opts="-h -v -l -p -s -o -S -g -d -c -q -L -M -D -I -t -b -m -V -f -r -T -w -e -u -B -y"
+    gd_short_opts="-h -v -l -p -s -o -S -g -d -c -q -L -M -D -I -t -b -m -V -f -r -T -w -e -u -B -y -j -k -W -Q"
 
-    gd_special="clean test help fmt strip print dryrun list"
+    gd_special="clean test help fmt strip print dryrun list query"
 
     COMPREPLY=()
 
@@ -56,7 +56,7 @@ _gd(){
     fi
 
     case "${cur}" in
-        c* | t* | h* | f* | s* | p* | d* | l*)
+        c* | t* | h* | f* | s* | p* | d* | l* | q*)
           COMPREPLY=( $(compgen -W "${gd_special}" -- "${cur}") )
           if [ "${#COMPREPLY[@]}" -gt 1 ]; then
               return 0
@@ -76,7 +76,15 @@ _gd(){
     if [[ "${prev}" == -* ]]; then
         case "${prev}" in
             '-B' |'-B=' | '-backend' |'--backend' |'-backend=' |'--backend=')
-                COMPREPLY=( $(compgen -W "gc gccgo express" -- "${cur}") )
+                COMPREPLY=( $(compgen -W "go gc gccgo express" -- "${cur}") )
+                return 0
+                ;;
+            '-goos' |'--goos' |'-goos=' |'--goos=')
+                COMPREPLY=( $(compgen -W "linux darwin windows freebsd netbsd openbsd plan9 solaris" -- "${cur}") )
+                return 0
+                ;;
+            '-goarch' |'--goarch' |'-goarch=' |'--goarch=')
+                COMPREPLY=( $(compgen -W "amd64 386 arm arm64" -- "${cur}") )
                 return 0
                 ;;
         esac